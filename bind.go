@@ -0,0 +1,86 @@
+package hu
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// GoFunction is an operator that calls a Go function, converting its
+// operands to the function's parameter types and its results to terms.
+type GoFunction struct {
+	Name     string
+	function reflect.Value
+}
+
+func (function GoFunction) String() string {
+	return fmt.Sprintf("#<go-function> %s", function.Name)
+}
+
+func (function GoFunction) apply(environment Environment, term Term) Term {
+	typ := function.function.Type()
+	operands := term.(Tuple)
+	n := typ.NumIn()
+	if typ.IsVariadic() && len(operands) < n-1 || !typ.IsVariadic() && len(operands) != n {
+		return Error(fmt.Sprintf("%s: expected %d arguments, got %d", function.Name, n, len(operands)))
+	}
+	arguments := make([]reflect.Value, len(operands))
+	for i, operand := range operands {
+		parameter := typ.In(min(i, n-1))
+		if typ.IsVariadic() && i >= n-1 {
+			parameter = parameter.Elem()
+		}
+		value, err := termToValue(evaluate(environment, operand), parameter)
+		if err != nil {
+			return Error(fmt.Sprintf("argument %d to %s: %v", i, function.Name, err))
+		}
+		arguments[i] = value
+	}
+	results := function.function.Call(arguments)
+	if k := len(results); k > 0 && typ.Out(k-1) == errorType {
+		if err, _ := results[k-1].Interface().(error); err != nil {
+			return Error(fmt.Sprintf("%s: %v", function.Name, err))
+		}
+		results = results[:k-1]
+	}
+	if len(results) > 1 {
+		allocate(environment, len(results))
+	}
+	c := &converter{path: make(map[visit]bool), allocate: func(n int) { allocate(environment, n) }}
+	terms := make(Tuple, len(results))
+	for i, result := range results {
+		term, err := c.term(result)
+		if err != nil {
+			return Error(fmt.Sprintf("result %d of %s: %v", i, function.Name, err))
+		}
+		terms[i] = term
+	}
+	switch len(terms) {
+	case 0:
+		return nil
+	case 1:
+		return terms[0]
+	}
+	return terms
+}
+
+// BindGo binds name to value in environment. A Go function is bound as a
+// GoFunction; numbers, strings, bools, slices, maps and structs are bound
+// as the corresponding terms. A function whose last result is an error
+// reports a non-nil error as an Error term; a function with several other
+// results returns them as a Tuple.
+func BindGo(environment Environment, name string, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Func {
+		if v.IsNil() {
+			return fmt.Errorf("cannot bind %s to a nil function", name)
+		}
+		environment.Define(Symbol(name), GoFunction{name, v})
+		return nil
+	}
+	term, err := valueToTerm(v)
+	if err != nil {
+		return err
+	}
+	environment.Define(Symbol(name), term)
+	return nil
+}
@@ -0,0 +1,91 @@
+package hu
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Owner   string
+	Balance float64 `hu:"balance"`
+	Tags    []string
+	secret  int
+}
+
+func TestBindGo(t *testing.T) {
+	environment := &LocalEnvironment{}
+	AddDefaultBindings(environment)
+	bindings := map[string]interface{}{
+		"add":   func(a, b int) int { return a + b },
+		"half":  func(x float64) float64 { return x / 2 },
+		"shout": func(s string) string { return strings.ToUpper(s) },
+		"not":   func(b bool) bool { return !b },
+		"sum": func(xs ...int) (total int) {
+			for _, x := range xs {
+				total += x
+			}
+			return
+		},
+		"count": func(xs []string) int { return len(xs) },
+		"keys":  func(m map[string]int) int { return len(m) },
+		"divide": func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"split":  func(a, b int) (int, int) { return a / b, a % b },
+		"open":   func(owner string) account { return account{Owner: owner, Balance: 10.5, Tags: []string{"new"}} },
+		"owner":  func(a account) string { return a.Owner },
+		"answer": 42,
+		"alice":  account{Owner: "alice", Balance: 1},
+	}
+	for name, value := range bindings {
+		if err := BindGo(environment, name, value); err != nil {
+			t.Fatalf("BindGo %s: %v", name, err)
+		}
+	}
+
+	tests := []testCase{
+		{"{add 1 2}", is_eq_number(3)},
+		{"{add 1 {add 2 3}}", is_eq_number(6)},
+		{"{half 5}", func(result Term) bool { return fmt.Sprint(result) == "5/2" }},
+		{`{shout "hu"}`, is_eq(String("HU"))},
+		{"{not false}", is_eq(Boolean(true))},
+		{"{sum 1 2 3 4}", is_eq_number(10)},
+		{"{sum}", is_eq_number(0)},
+		{`{count ("a" "b")}`, is_eq_number(2)},
+		{"{keys {record (a 1) (b 2)}}", is_eq_number(2)},
+		{"{divide 7 2}", is_eq_number(3)},
+		{"{divide 7 0}", is_error()},
+		{"{split 7 2}", is_tuple()},
+		{"{add 1}", is_error()},
+		{`{add "one" 2}`, is_error()},
+		{"answer", is_eq_number(42)},
+		{`{field {open "bob"} balance}`, func(result Term) bool { return fmt.Sprint(result) == "21/2" }},
+		{`{field {open "bob"} Tags}`, is_tuple()},
+		{`{field {open "bob"} secret}`, is_unbound()},
+		{"{owner alice}", is_eq(String("alice"))},
+	}
+	for _, test := range tests {
+		result := GuardedEvaluate(environment, Read(strings.NewReader(test.input)))
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+
+	if err := BindGo(environment, "channel", make(chan int)); err == nil {
+		t.Errorf("expected an error binding a channel")
+	}
+
+	interpreter := NewInterpreter(WithLimits(Limits{Elements: 100}))
+	BindGo(interpreter.Environment(), "numbers", func(n int) []int { return make([]int, n) })
+	if result := interpreter.Eval("{numbers 10}"); !is_tuple()(result) {
+		t.Errorf("expected a tuple, got %v", result)
+	}
+	if result := interpreter.Eval("{numbers 1000}"); !is_exhausted()(result) {
+		t.Errorf("expected the result of numbers to exhaust the elements, got %v", result)
+	}
+}
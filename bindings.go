@@ -2,44 +2,156 @@ package hu
 
 import "strings"
 
+// A PrimitiveSet adds a group of related primitives to an environment.
+type PrimitiveSet func(Environment)
+
 func AddPrimitive(environment Environment, name string, function PrimitiveFunction) {
 	environment.Define(Symbol(name), function)
 }
 
-func AddDefaultBindings(environment Environment) {
+func AddPrimitives(environment Environment, sets ...PrimitiveSet) {
+	for _, set := range sets {
+		set(environment)
+	}
+}
+
+// DefaultPrimitives are the primitive sets bound by AddDefaultBindings.
+func DefaultPrimitives() []PrimitiveSet {
+	return []PrimitiveSet{CorePrimitives, ArithmeticPrimitives, TuplePrimitives, RecordPrimitives, JSONPrimitives, OutputPrimitives, SchedulePrimitives, ConcurrencyPrimitives}
+}
+
+// bindingForms are the core forms that bind variables, each with a
+// function that appends the variables a use of it binds, given its
+// operands, to names. Macro expansion renames these variables.
+var bindingForms = []struct {
+	name    string
+	form    PrimitiveFunction
+	binders func(operands Tuple, names []Symbol) []Symbol
+}{
+	{"lambda", lambda, lambdaBinders},
+	{"operator", operator, operatorBinders},
+	{"define", define, defineBinders},
+	{"variable", variable, nameBinders},
+	{"computed", computed, nameBinders},
+	{"let", let, letBinders},
+	{"let*", letStar, letBinders},
+	{"letrec", letrec, letBinders},
+	{"for", forPrimitive, forBinders},
+	{"shift", shift, nameBinders},
+	{"match", matchPrimitive, matchBinders},
+}
+
+func CorePrimitives(environment Environment) {
 	environment.Define("true", Boolean(true))
 	environment.Define("false", Boolean(false))
 
-	AddPrimitive(environment, "lambda", lambda)
-	AddPrimitive(environment, "operator", operator)
+	for _, form := range bindingForms {
+		AddPrimitive(environment, form.name, form.form)
+	}
+
+	AddPrimitive(environment, "observe", observe)
+	AddPrimitive(environment, "unobserve", unobserve)
+	AddPrimitive(environment, "willSet", willSet)
+	AddPrimitive(environment, "veto", veto)
+	AddPrimitive(environment, "transaction", transactionPrimitive)
+	AddPrimitive(environment, "set", set)
+	AddPrimitive(environment, "get", get)
+	AddPrimitive(environment, "begin", begin)
+	AddPrimitive(environment, "if", ifPrimitive)
+	AddPrimitive(environment, "and", and)
+	AddPrimitive(environment, "or", or)
+	AddPrimitive(environment, "apply", apply)
+	AddPrimitive(environment, "eval", evalPrimitive)
+	AddPrimitive(environment, "cond", cond)
+	AddPrimitive(environment, "case", caseForm)
+	AddPrimitive(environment, "when", when)
+	AddPrimitive(environment, "unless", unless)
+	AddPrimitive(environment, "while", while)
+	AddPrimitive(environment, "raise", raise)
+	AddPrimitive(environment, "try", try)
+	AddPrimitive(environment, "reset", reset)
+	AddPrimitive(environment, "block", blockPrimitive)
+	AddPrimitive(environment, "return", returnPrimitive)
+	AddPrimitive(environment, "define-syntax", defineSyntax)
+	AddPrimitive(environment, "syntax-rules", syntaxRules)
+	AddPrimitive(environment, "macroexpand", macroexpand)
+	AddPrimitive(environment, "quote", quote)
+	AddPrimitive(environment, "quasiquote", quasiquote)
+}
 
+func ArithmeticPrimitives(environment Environment) {
 	AddPrimitive(environment, "=", is_number_equal_proc)
 	AddPrimitive(environment, "<", is_less_than_proc)
 	AddPrimitive(environment, ">", is_greater_than_proc)
 
 	AddPrimitive(environment, "+", add_numbers)
 	environment.Define("add_numbers", Primitive(add_numbersP))
-	AddPrimitive(environment, "concat", add_lists)
 
 	AddPrimitive(environment, "-", subtract_proc)
 
 	AddPrimitive(environment, "*", multiply_proc)
 	//AddPrimitive(environment, "quotient", quotient_proc)
 	//AddPrimitive(environment, "remainder", remainder_proc)
+}
 
-	AddPrimitive(environment, "define", define)
-	AddPrimitive(environment, "variable", variable)
-	AddPrimitive(environment, "set", set)
-	AddPrimitive(environment, "get", get)
-	AddPrimitive(environment, "begin", begin)
-	AddPrimitive(environment, "if", ifPrimitive)
-	AddPrimitive(environment, "and", and)
-	AddPrimitive(environment, "or", or)
-	AddPrimitive(environment, "apply", apply)
-	AddPrimitive(environment, "eval", evalPrimitive)
-	AddPrimitive(environment, "let", let)
+func TuplePrimitives(environment Environment) {
+	AddPrimitive(environment, "concat", add_lists)
+	AddPrimitive(environment, "set-of", setOf)
+}
+
+func RecordPrimitives(environment Environment) {
+	AddPrimitive(environment, "record", record)
+	AddPrimitive(environment, "field", field)
+}
+
+func JSONPrimitives(environment Environment) {
+	AddPrimitive(environment, "json-parse", jsonParse)
+	AddPrimitive(environment, "json-stringify", jsonStringify)
+}
+
+// OutputPrimitives write to the standard output and error of the
+// interpreter.
+func OutputPrimitives(environment Environment) {
+	AddPrimitive(environment, "print", printPrimitive)
+	AddPrimitive(environment, "warn", warnPrimitive)
+}
+
+// SchedulePrimitives schedule jobs on the event loop of the interpreter.
+func SchedulePrimitives(environment Environment) {
+	AddPrimitive(environment, "after", after)
+	AddPrimitive(environment, "every", every)
+	AddPrimitive(environment, "at", at)
+	AddPrimitive(environment, "cancel", cancel)
+}
+
+// ConcurrencyPrimitives run tasks on goroutines that communicate over
+// channels. Tasks share the environments they are spawned in, so spawn
+// is an error in an environment not safe for concurrent use.
+func ConcurrencyPrimitives(environment Environment) {
+	AddPrimitive(environment, "channel", channelPrimitive)
+	AddPrimitive(environment, "send", sendPrimitive)
+	AddPrimitive(environment, "receive", receive)
+	AddPrimitive(environment, "close", closePrimitive)
+	AddPrimitive(environment, "spawn", spawn)
+	AddPrimitive(environment, "wait", wait)
+	AddPrimitive(environment, "select", selectPrimitive)
+}
+
+// prelude defines helpers on top of the primitives. A generator's body
+// calls yield for each value, and each applies a handler to them.
+var prelude = []string{
+	"{define (yield (value)) {shift k {record (done false) (value value) (next k)}}}",
+	"{define (generate (body)) {reset body {record (done true)}}}",
+	"{define (each (generator handler)) {begin {define g 0} {set g generator} {if {field g done} true {begin {handler {field g value}} {each {{field g next}} handler}}}}}",
+}
+
+func AddDefaultBindings(environment Environment) {
+	AddPrimitives(environment, DefaultPrimitives()...)
 
-	Evaluate(environment, Read(strings.NewReader(`{define plus {operator ((lhs) (rhs)) {+ lhs rhs}}}
+	evaluate(environment, Read(strings.NewReader(`{define plus {operator ((lhs) (rhs)) {+ lhs rhs}}}
 {define plus_list_operator {operator (lhs rhs) {concat lhs rhs}}} {1 2 plus 3 4}}
 	`)))
+	for _, definition := range prelude {
+		evaluate(environment, Read(strings.NewReader(definition)))
+	}
 }
@@ -0,0 +1,97 @@
+package hu
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and calls functions after a delay.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a pending call made by a Clock. Stop prevents the call, and
+// reports whether it did so.
+type Timer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock is a Clock whose time only moves when it is advanced, for
+// testing. It is safe for concurrent use.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	f     func()
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	timer := &fakeTimer{clock, clock.now.Add(d), f}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d, calling the functions that come
+// due in the order of their times, with the clock set to each time.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	end := clock.now.Add(d)
+	for {
+		sort.SliceStable(clock.timers, func(i, j int) bool {
+			return clock.timers[i].when.Before(clock.timers[j].when)
+		})
+		if len(clock.timers) == 0 || clock.timers[0].when.After(end) {
+			break
+		}
+		timer := clock.timers[0]
+		clock.timers = clock.timers[1:]
+		clock.now = timer.when
+		clock.mutex.Unlock()
+		timer.f()
+		clock.mutex.Lock()
+	}
+	clock.now = end
+	clock.mutex.Unlock()
+}
+
+func (timer *fakeTimer) Stop() bool {
+	clock := timer.clock
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	for i, t := range clock.timers {
+		if t == timer {
+			clock.timers = append(clock.timers[:i:i], clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
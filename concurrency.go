@@ -0,0 +1,340 @@
+package hu
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// Channel is a channel of terms, for communication between tasks.
+type Channel struct {
+	C chan Term
+}
+
+// NewChannel returns a channel that buffers up to capacity terms.
+func NewChannel(capacity int) *Channel {
+	return &Channel{make(chan Term, capacity)}
+}
+
+func (channel *Channel) String() string {
+	return "#<channel>"
+}
+
+// Future is the result of a task started by spawn, once it finishes.
+type Future struct {
+	done   chan struct{}
+	result Term
+}
+
+func (future *Future) String() string {
+	return "#<future>"
+}
+
+// Wait returns the result of the task, waiting for it to finish if need
+// be, or an error if ctx is done first.
+func (future *Future) Wait(ctx context.Context) (Term, error) {
+	select {
+	case <-future.done:
+		return future.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// contextOf returns the context of the evaluation environment is part
+// of.
+func contextOf(environment Environment) context.Context {
+	if c := controlOf(environment); c != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// clockOf returns the clock of the interpreter evaluating in environment.
+func clockOf(environment Environment) Clock {
+	if interpreter := interpreterOf(environment); interpreter != nil {
+		return interpreter.clock
+	}
+	return systemClock{}
+}
+
+// canceled aborts the evaluation environment is part of because ctx is
+// done.
+func canceled(ctx context.Context) Term {
+	panic(CanceledError{ctx.Err()})
+}
+
+func channelPrimitive(environment Environment, term Term) Term {
+	capacity := 0
+	if terms := term.(Tuple); len(terms) > 0 {
+		n, ok := evaluate(environment, terms[0]).(*Number)
+		if !ok {
+			return Error("unexpected type for capacity")
+		}
+		c, ok := n.Int64()
+		if !ok || c < 0 || c > 1<<20 {
+			return Error(fmt.Sprintf("invalid capacity %v", n))
+		}
+		capacity = int(c)
+	}
+	allocate(environment, capacity)
+	return NewChannel(capacity)
+}
+
+func channelOf(environment Environment, term Term) (*Channel, Term) {
+	channel, ok := evaluate(environment, term).(*Channel)
+	if !ok {
+		return nil, Error("unexpected type for channel")
+	}
+	return channel, nil
+}
+
+// send sends value on channel, returning an error if the channel is
+// closed.
+func send(ctx context.Context, channel *Channel, value Term) Term {
+	sent := false
+	if err := guardSend(func() {
+		select {
+		case channel.C <- value:
+			sent = true
+		case <-ctx.Done():
+		}
+	}); err != nil {
+		return err
+	}
+	if !sent {
+		return canceled(ctx)
+	}
+	return nil
+}
+
+// guardSend calls f, which sends on a channel, and returns an error if
+// the channel is closed. Any other panic is passed on.
+func guardSend(f func()) (result Term) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(runtime.Error); ok && err.Error() == "send on closed channel" {
+				result = Error("send on closed channel")
+				return
+			}
+			panic(r)
+		}
+	}()
+	f()
+	return nil
+}
+
+func sendPrimitive(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) != 2 {
+		return Error("send needs a channel and a value")
+	}
+	channel, err := channelOf(environment, terms[0])
+	if err != nil {
+		return err
+	}
+	return send(contextOf(environment), channel, evaluate(environment, terms[1]))
+}
+
+// receive returns the next value on a channel, or nil once it is closed.
+func receive(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) != 1 {
+		return Error("receive needs a channel")
+	}
+	channel, err := channelOf(environment, terms[0])
+	if err != nil {
+		return err
+	}
+	ctx := contextOf(environment)
+	select {
+	case value := <-channel.C:
+		return value
+	case <-ctx.Done():
+		return canceled(ctx)
+	}
+}
+
+func closePrimitive(environment Environment, term Term) (result Term) {
+	terms := term.(Tuple)
+	if len(terms) != 1 {
+		return Error("close needs a channel")
+	}
+	channel, err := channelOf(environment, terms[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if recover() != nil {
+			result = Error("close of closed channel")
+		}
+	}()
+	close(channel.C)
+	return nil
+}
+
+// spawn evaluates its operands in order on a new goroutine, in a new
+// environment extending the current one, and returns a Future for the
+// result of the last. The task has its own control, with the context of
+// the evaluation that spawned it, and is charged to the same budget, so
+// neither outlives its limits. An abort or panic in the task becomes its
+// result.
+func spawn(environment Environment, term Term) Term {
+	if !concurrent(environment) {
+		return Error("spawn in an environment not safe for concurrent use")
+	}
+	caller := environment
+	if c := controlOf(environment); c != nil {
+		caller = &controlledEnvironment{environment, c.task()}
+	}
+	task := &NestedEnvironment{Environment: newBindings(environment), Parent: environment, caller: caller}
+	future := &Future{done: make(chan struct{})}
+	go func() {
+		defer close(future.done)
+		future.result = GuardedEvaluate(task, Primitive(func(environment Environment) Term {
+			return begin(environment, term)
+		}))
+	}()
+	return future
+}
+
+// wait returns the result of a future, or an error if it does not finish
+// within the optional timeout.
+func wait(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) != 1 && len(terms) != 2 {
+		return Error("wait needs a future and an optional timeout")
+	}
+	future, ok := evaluate(environment, terms[0]).(*Future)
+	if !ok {
+		return Error("unexpected type for future")
+	}
+	var expired <-chan struct{}
+	if len(terms) == 2 {
+		d, ok := duration(evaluate(environment, terms[1]))
+		if !ok {
+			return Error("invalid timeout")
+		}
+		var stop func()
+		expired, stop = timeout(environment, d)
+		defer stop()
+	}
+	ctx := contextOf(environment)
+	select {
+	case <-future.done:
+		return future.result
+	case <-expired:
+		return Error("wait timed out")
+	case <-ctx.Done():
+		return canceled(ctx)
+	}
+}
+
+// timeout returns a channel that is closed once d has passed on the clock
+// of the interpreter evaluating in environment, and a function that
+// stops the timer.
+func timeout(environment Environment, d time.Duration) (<-chan struct{}, func()) {
+	expired := make(chan struct{})
+	timer := clockOf(environment).AfterFunc(d, func() { close(expired) })
+	return expired, func() { timer.Stop() }
+}
+
+// selectPrimitive waits until one of its clauses can proceed, and then
+// applies that clause's handler, if it has one:
+//
+//	(receive channel handler)      applies handler to the value received
+//	(send channel value handler)   applies handler with no operands
+//	(timeout duration handler)     applies handler after duration
+//	(default handler)              applies handler if no other can proceed
+//
+// Without a handler, a receive clause results in the value received and
+// the others in nil.
+func selectPrimitive(environment Environment, term Term) Term {
+	ctx := contextOf(environment)
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+	var handlers []Term
+	var receives []bool
+	for _, clause := range term.(Tuple) {
+		terms, ok := clause.(Tuple)
+		if !ok || len(terms) == 0 {
+			return Error(fmt.Sprintf("malformed select clause %v", clause))
+		}
+		var c reflect.SelectCase
+		var rest Tuple
+		switch terms[0] {
+		case Symbol("receive"):
+			if len(terms) < 2 {
+				return Error("receive clause needs a channel")
+			}
+			channel, err := channelOf(environment, terms[1])
+			if err != nil {
+				return err
+			}
+			c, rest = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.C)}, terms[2:]
+		case Symbol("send"):
+			if len(terms) < 3 {
+				return Error("send clause needs a channel and a value")
+			}
+			channel, err := channelOf(environment, terms[1])
+			if err != nil {
+				return err
+			}
+			value := reflect.ValueOf(evaluate(environment, terms[2]))
+			if !value.IsValid() {
+				value = reflect.Zero(reflect.TypeOf((*Term)(nil)).Elem())
+			}
+			c, rest = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(channel.C), Send: value}, terms[3:]
+		case Symbol("timeout"):
+			if len(terms) < 2 {
+				return Error("timeout clause needs a duration")
+			}
+			d, ok := duration(evaluate(environment, terms[1]))
+			if !ok {
+				return Error("invalid timeout")
+			}
+			expired, stop := timeout(environment, d)
+			defer stop()
+			c, rest = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(expired)}, terms[2:]
+		case Symbol("default"):
+			c, rest = reflect.SelectCase{Dir: reflect.SelectDefault}, terms[1:]
+		default:
+			return Error(fmt.Sprintf("unknown select clause %v", terms[0]))
+		}
+		var handler Term
+		if len(rest) > 0 {
+			handler = evaluate(environment, rest[0])
+			if _, ok := handler.(Operator); !ok {
+				return Error("unexpected type for handler")
+			}
+		}
+		cases = append(cases, c)
+		handlers = append(handlers, handler)
+		receives = append(receives, terms[0] == Symbol("receive"))
+	}
+
+	var chosen int
+	var value reflect.Value
+	if err := guardSend(func() {
+		chosen, value, _ = reflect.Select(cases)
+	}); err != nil {
+		return err
+	}
+	if chosen == 0 {
+		return canceled(ctx)
+	}
+	handler, receive := handlers[chosen-1], receives[chosen-1]
+	var received Term
+	if receive && value.IsValid() && !value.IsNil() {
+		received = value.Interface().(Term)
+	}
+	switch {
+	case handler == nil:
+		return received
+	case receive:
+		return evaluate(environment, Application{handler, received})
+	default:
+		return evaluate(environment, Application{handler})
+	}
+}
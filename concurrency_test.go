@@ -0,0 +1,130 @@
+package hu
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrency(t *testing.T) {
+	interpreter := NewInterpreter(WithEnvironment(NewSyncEnvironment()))
+	interpreter.Eval(`{define ch 0}
+{set ch {channel}}
+{define buffered 0}
+{set buffered {channel 1}}
+{define never 0}
+{set never {channel}}
+{define task 0}
+{set task {spawn {send ch 1} {send ch 2} {close ch} "done"}}`)
+
+	tests := []testCase{
+		{"{receive ch}", is_eq_number(1)},
+		{"{receive ch}", is_eq_number(2)},
+		{"{receive ch}", is_eq(nil)},
+		{"{wait task}", is_eq(String("done"))},
+		{"{send ch 3}", is_error()},
+		{"{close ch}", is_error()},
+		{"{wait {spawn {+ 1 2}}}", is_eq_number(3)},
+		{`{wait {spawn {+ 1 "two"}}}`, is_error()},
+		{"{wait {spawn {set}}}", is_error()},
+		{"{wait {spawn {define local 1} local}}", is_eq_number(1)},
+		{"local", is_unbound()},
+		{`{wait {spawn {receive never}} "10ms"}`, is_error()},
+		{"{begin {send buffered 5} {select (receive never) (receive buffered {lambda (v) {+ v 1}})}}", is_eq_number(6)},
+		{`{select (receive never) (timeout "10ms" {lambda () "late"})}`, is_eq(String("late"))},
+		{`{select (receive never) (default {lambda () "none"})}`, is_eq(String("none"))},
+		{"{begin {select (send buffered 7) (default)} {receive buffered}}", is_eq_number(7)},
+		{"{select (receive buffered) (default)}", is_eq(nil)},
+		{"{select (listen buffered)}", is_error()},
+		{"{receive 1}", is_error()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+	interpreter.Eval("{close never}")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if result := interpreter.Evaluate(ctx, Read(strings.NewReader("{receive {channel}}"))); !is_canceled()(result) {
+		t.Errorf("expected a blocked receive to be canceled, got %v", result)
+	}
+
+	in := NewChannel(0)
+	interpreter.Define("in", in)
+	future, ok := interpreter.Eval("{spawn {* 2 {receive in}}}").(*Future)
+	if !ok {
+		t.Fatalf("expected a future")
+	}
+	in.C <- NewNumber(big.NewRat(21, 1))
+	if result, err := future.Wait(context.Background()); err != nil || !is_eq_number(42)(result) {
+		t.Errorf("expected 42, got %v %v", result, err)
+	}
+}
+
+func TestConcurrencyControl(t *testing.T) {
+	evaluate := func(ctx context.Context, input string, limits Limits) Term {
+		interpreter := NewInterpreter(WithEnvironment(NewSyncEnvironment()), WithLimits(limits))
+		interpreter.Eval("{define (count (n)) {if {< n 1} 0 {count {- n 1}}}}")
+		return interpreter.Evaluate(ctx, Read(strings.NewReader(input)))
+	}
+	background := context.Background()
+
+	// Errors other than sending on a closed channel pass through send and
+	// select.
+	ctx, cancel := context.WithTimeout(background, 10*time.Millisecond)
+	defer cancel()
+	if result := evaluate(ctx, "{send {channel} 1}", Limits{}); !is_canceled()(result) {
+		t.Errorf("send: expected canceled error, got %v", result)
+	}
+	if result := evaluate(ctx, "{select (send {channel} 1)}", Limits{}); !is_canceled()(result) {
+		t.Errorf("select: expected canceled error, got %v", result)
+	}
+	if result := evaluate(background, "{try {select (default {lambda () {raise boom}})} (catch boom {lambda (e) 1})}", Limits{}); !is_eq_number(1)(result) {
+		t.Errorf("raise in select: expected 1, got %v", result)
+	}
+	if result := evaluate(background, "{select (default {lambda () {count 1000}})}", Limits{Steps: 500}); !is_exhausted()(result) {
+		t.Errorf("steps in select: expected exhausted error, got %v", result)
+	}
+
+	// Tasks are charged to the budget of the evaluation spawning them.
+	if result := evaluate(background, "{wait {spawn {count 10}}}", Limits{Steps: 500}); !is_eq_number(0)(result) {
+		t.Errorf("task within limits: expected 0, got %v", result)
+	}
+	if result := evaluate(background, "{begin {wait {spawn {count 10}}} {wait {spawn {count 10}}} {wait {spawn {count 10}}}}", Limits{Steps: 500}); !is_exhausted()(result) {
+		t.Errorf("tasks: expected exhausted error, got %v", result)
+	}
+	if result := evaluate(background, "{wait {spawn {begin {count 10} {wait {spawn {begin {count 10} {wait {spawn {count 10}}}}}}}}}", Limits{Steps: 500}); !is_exhausted()(result) {
+		t.Errorf("nested tasks: expected exhausted error, got %v", result)
+	}
+	if result := evaluate(background, "{begin {spawn 1} {spawn 2} {spawn 3}}", Limits{Tasks: 2}); !is_exhausted()(result) {
+		t.Errorf("task limit: expected exhausted error, got %v", result)
+	}
+}
+
+func TestConcurrencyShared(t *testing.T) {
+	// Run with -race: tasks set variables in the environments they share
+	// with the goroutine that spawned them.
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define x 0}
+{define task 0}
+{define (shared ()) {begin {define y 0} {define task 0} {set task {spawn {set y 1}}} {set y 2} {wait task} y}}`)
+	for i := 0; i < 10; i++ {
+		if result := interpreter.Eval("{begin {set task {spawn {set x 1}}} {set x 2} {wait task} x}"); !is_number()(result) {
+			t.Errorf("global: expected a number, got %v", result)
+		}
+		if result := interpreter.Eval("{shared}"); !is_number()(result) {
+			t.Errorf("frame: expected a number, got %v", result)
+		}
+	}
+
+	environment := make(LocalEnvironment)
+	AddDefaultBindings(environment)
+	if result := GuardedEvaluate(environment, Read(strings.NewReader("{spawn 1}"))); !is_error()(result) {
+		t.Errorf("expected spawn in a LocalEnvironment to fail, got %v", result)
+	}
+}
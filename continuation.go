@@ -0,0 +1,257 @@
+package hu
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+)
+
+// {reset body...} delimits the evaluation of body, and {shift k expression},
+// evaluated as part of body, suspends it and evaluates expression in place
+// of the whole reset, with k bound to a continuation that resumes body where
+// it left off. The evaluator is written in direct style, with primitives
+// evaluating their operands by calling evaluate, so it cannot capture the
+// rest of an evaluation as data. Instead the body of a reset runs on its
+// own goroutine, which is suspended while it waits for its continuation,
+// so continuations are one-shot: applying one a second time is an error.
+// A body whose continuation is never applied is abandoned once the
+// continuation is garbage collected, or when the context of the evaluation
+// is done.
+//
+// {block body...} and {return value}, which only exit early, do not need a
+// continuation: return unwinds the evaluation to the innermost block.
+
+// Continuation is the rest of the body of a reset, suspended by shift.
+type Continuation struct {
+	prompt *prompt
+	resume chan Term
+	used   atomic.Bool
+}
+
+func (continuation *Continuation) String() string {
+	return "#<continuation>"
+}
+
+func (continuation *Continuation) apply(environment Environment, operands Term) Term {
+	terms := operands.(Tuple)
+	if len(terms) > 1 {
+		return Error("a continuation takes at most one value")
+	}
+	var value Term
+	if len(terms) == 1 {
+		value = evaluate(environment, terms[0])
+	}
+	if !continuation.used.CompareAndSwap(false, true) {
+		return Error("continuation has already been resumed")
+	}
+	ctx := contextOf(environment)
+	select {
+	case continuation.resume <- value:
+	case <-continuation.prompt.exited:
+		return Error("continuation can no longer be resumed")
+	case <-ctx.Done():
+		return canceled(ctx)
+	}
+	return continuation.prompt.drive(environment)
+}
+
+// prompt delimits the evaluation of the body of a reset. Its context is
+// canceled when the body finishes or is abandoned.
+type prompt struct {
+	events chan event
+	exited chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// event is sent by the body of a reset when it shifts, with the
+// continuation and the expression of the shift, or when it finishes, with
+// its result or what it panicked with.
+type event struct {
+	continuation *Continuation
+	name         Symbol
+	expression   Term
+	environment  Environment
+	result       Term
+	recovered    interface{}
+}
+
+// promptEnvironment marks the evaluation of the body of a reset.
+type promptEnvironment struct {
+	Environment
+	prompt *prompt
+}
+
+func (environment *promptEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *promptEnvironment) wrapped() Environment {
+	return environment.Environment
+}
+
+// promptOf returns the prompt of the innermost reset the evaluation
+// environment is part of, or nil if there is none.
+func promptOf(environment Environment) *prompt {
+	for ; environment != nil; environment = outer(environment) {
+		if e, ok := environment.(*promptEnvironment); ok {
+			return e.prompt
+		}
+	}
+	return nil
+}
+
+// run evaluates the body of a reset and sends its result.
+func (p *prompt) run(environment Environment, body Term) {
+	defer p.cancel()
+	defer close(p.exited)
+	var e event
+	func() {
+		defer func() {
+			e.recovered = recover()
+		}()
+		e.result = evaluate(environment, begin(environment, body))
+	}()
+	p.send(e)
+}
+
+// send sends e to whoever is waiting for the body of the reset, and
+// reports whether it was received before the context was done.
+func (p *prompt) send(e event) bool {
+	select {
+	case p.events <- e:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// drive waits, as part of the evaluation in environment, for the body of
+// the reset to shift or finish. It returns the result of the body or,
+// when it shifts, the expression of the shift to be evaluated in its
+// place.
+func (p *prompt) drive(environment Environment) Term {
+	ctx := contextOf(environment)
+	select {
+	case e := <-p.events:
+		if e.continuation == nil {
+			if e.recovered != nil {
+				panic(e.recovered)
+			}
+			return e.result
+		}
+		frame := &NestedEnvironment{Environment: newBindings(e.environment), Parent: e.environment, caller: environment}
+		frame.Define(e.name, e.continuation)
+		return Closure{e.expression, frame}
+	case <-p.exited:
+		return Error("continuation can no longer be resumed")
+	case <-ctx.Done():
+		return canceled(ctx)
+	}
+}
+
+func reset(environment Environment, term Term) Term {
+	p := &prompt{events: make(chan event), exited: make(chan struct{})}
+	p.ctx, p.cancel = context.WithCancel(contextOf(environment))
+	go p.run(&promptEnvironment{environment, p}, term)
+	return p.drive(environment)
+}
+
+func shift(environment Environment, term Term) Term {
+	// k {k 1}
+	terms := term.(Tuple)
+	if len(terms) != 2 {
+		return Error("shift needs a name and an expression")
+	}
+	name, ok := terms[0].(Symbol)
+	if !ok {
+		return Error("unexpected type for name")
+	}
+	p := promptOf(environment)
+	if p == nil {
+		return Error("shift outside of reset")
+	}
+	// The body waits on resume only, so that once nothing else refers to
+	// the continuation it can be collected, abandoning the body.
+	resume := make(chan Term)
+	continuation := &Continuation{prompt: p, resume: resume}
+	runtime.SetFinalizer(continuation, func(continuation *Continuation) {
+		if !continuation.used.Load() {
+			continuation.prompt.cancel()
+		}
+	})
+	if !p.send(event{continuation: continuation, name: name, expression: terms[1], environment: environment}) {
+		return canceled(p.ctx)
+	}
+	select {
+	case value := <-resume:
+		return value
+	case <-p.ctx.Done():
+		return canceled(p.ctx)
+	}
+}
+
+// exit is the point a block returns to.
+type exit struct {
+	done atomic.Bool
+}
+
+// escape is what return panics with to unwind the evaluation to its block.
+type escape struct {
+	exit  *exit
+	value Term
+}
+
+func (escape *escape) String() string {
+	return "return outside of its block"
+}
+
+// blockEnvironment marks the evaluation of the body of a block.
+type blockEnvironment struct {
+	Environment
+	exit *exit
+}
+
+func (environment *blockEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *blockEnvironment) wrapped() Environment {
+	return environment.Environment
+}
+
+func blockPrimitive(environment Environment, term Term) (result Term) {
+	x := &exit{}
+	defer func() {
+		x.done.Store(true)
+		if r := recover(); r != nil {
+			if e, ok := r.(*escape); ok && e.exit == x {
+				result = e.value
+				return
+			}
+			panic(r)
+		}
+	}()
+	body := &blockEnvironment{environment, x}
+	return evaluate(body, begin(body, term))
+}
+
+func returnPrimitive(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) > 1 {
+		return Error("return takes at most one value")
+	}
+	var value Term
+	if len(terms) == 1 {
+		value = evaluate(environment, terms[0])
+	}
+	for e := environment; e != nil; e = outer(e) {
+		if b, ok := e.(*blockEnvironment); ok {
+			if b.exit.done.Load() {
+				return Error("return from a block that has finished")
+			}
+			panic(&escape{b.exit, value})
+		}
+	}
+	return Error("return outside of block")
+}
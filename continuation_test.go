@@ -0,0 +1,89 @@
+package hu
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContinuation(t *testing.T) {
+	environment := make(LocalEnvironment)
+	AddDefaultBindings(environment)
+
+	tests := []testCase{
+		{"{reset {+ 1 {shift k {k 2}}}}", is_eq_number(3)},
+		{"{reset {+ 1 {shift k 5}}}", is_eq_number(5)},
+		{"{reset {* 2 {shift k {+ {k 3} 1}}}}", is_eq_number(7)},
+		{"{reset {+ 1 {shift a {a 1}}} {+ 10 {shift b {b 2}}}}", is_eq_number(12)},
+		{"{reset {+ 1 {reset {+ 10 {shift k 100}}}}}", is_eq_number(101)},
+		{"{reset {* 2 {shift k {k {k 3}}}}}", is_error()},
+		{"{shift k 1}", is_error()},
+		{"{try {reset {+ 1 {shift k {raise oops}}}} (catch oops {lambda (e) 7})}", is_eq_number(7)},
+		{"{try {reset {raise oops}} (catch oops {lambda (e) 8})}", is_eq_number(8)},
+		{"{block {begin 1 {return 2} 3}}", is_eq_number(2)},
+		{"{block {+ 1 2}}", is_eq_number(3)},
+		{"{block 1 {return} 3}", is_nil()},
+		{"{block {+ 1 {block {return 2}}}}", is_eq_number(3)},
+		{"{block {try {return 1} (catch {lambda (e) 2})}}", is_eq_number(1)},
+		{"{begin {define n 0} {block {try {return 1} (finally {set n 5})}} n}", is_eq_number(5)},
+		{"{block {each {generate {begin {yield 1} {return 5}}} {lambda (x) x}}}", is_eq_number(5)},
+		{"{return 1}", is_error()},
+		{`{begin {define (sign (x)) {if {< x 0} {return "negative"} x}} {block {+ {sign 1} {sign {- 0 1}}}}}`, is_eq(String("negative"))},
+		{"{field {generate {begin {yield 1} {yield 2}}} value}", is_eq_number(1)},
+		{"{field {{field {generate {begin {yield 1} {yield 2}}} next}} value}", is_eq_number(2)},
+		{"{field {generate 1} done}", is_eq(Boolean(true))},
+		{"{begin {define total 0} {each {generate {begin {yield 1} {yield 2} {yield 3}}} {lambda (x) {set total {+ total x}}}} total}", is_eq_number(6)},
+		{"{begin {define (upto (n)) {generate {begin {define i 0} {set i 1} {each-number i n}}}} {define (each-number (i n)) {if {> i n} true {begin {yield i} {each-number {+ i 1} n}}}} {define total 0} {each {upto 4} {lambda (x) {set total {+ total x}}}} total}", is_eq_number(10)},
+	}
+	for _, test := range tests {
+		result := GuardedEvaluate(environment, Read(strings.NewReader(test.input)))
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestContinuationContext(t *testing.T) {
+	environment := make(LocalEnvironment)
+	AddDefaultBindings(environment)
+	ctx, cancel := context.WithCancel(context.Background())
+	generator := EvaluateContext(ctx, environment, Read(strings.NewReader("{generate {begin {yield 1} {yield 2}}}")))
+	record, ok := generator.(Record)
+	if !ok {
+		t.Fatalf("expected a record, got %v", generator)
+	}
+	environment.Define("next", record["next"])
+	cancel()
+	if result := GuardedEvaluate(environment, Read(strings.NewReader("{next}"))); !isError(result) {
+		t.Errorf("resuming a canceled generator unexpectedly resulted in %v", result)
+	}
+}
+
+func TestContinuationAbandoned(t *testing.T) {
+	environment := make(LocalEnvironment)
+	AddDefaultBindings(environment)
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		if result := GuardedEvaluate(environment, Read(strings.NewReader("{block {begin 1 {return 2} 3}}"))); !is_eq_number(2)(result) {
+			t.Fatalf("block unexpectedly resulted in %v", result)
+		}
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("blocks left %d goroutines running, from %d", n, before)
+	}
+	for i := 0; i < 200; i++ {
+		if result := GuardedEvaluate(environment, Read(strings.NewReader("{field {generate {begin {yield 1} {yield 2}}} value}"))); !is_eq_number(1)(result) {
+			t.Fatalf("generate unexpectedly resulted in %v", result)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+10 {
+		t.Errorf("abandoned generators left %d goroutines running, from %d", n, before)
+	}
+}
@@ -0,0 +1,195 @@
+package hu
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+)
+
+// Limits bounds the work a single evaluation may do. A zero value for
+// any field means that there is no limit on that resource.
+type Limits struct {
+	Steps    int // maximum number of reductions
+	Depth    int // maximum nesting of Evaluate
+	Elements int // maximum number of tuple elements built
+	Bits     int // maximum size of a number in bits
+	Bindings int // maximum number of variables bound
+	Tasks    int // maximum number of tasks spawned
+}
+
+type CanceledError struct {
+	err error
+}
+
+func (e CanceledError) String() string {
+	return "Canceled: " + e.err.Error()
+}
+
+type ExhaustedError struct {
+	resource string
+	limit    int
+}
+
+func (e ExhaustedError) String() string {
+	return fmt.Sprintf("Exhausted: %s limit of %d", e.resource, e.limit)
+}
+
+// control holds the state of an evaluation started by EvaluateContext or
+// by an Interpreter, or of a task it spawned.
+type control struct {
+	ctx         context.Context
+	limits      Limits
+	interpreter *Interpreter
+	depth       atomic.Int64
+	*budget
+}
+
+// budget counts the resources used by an evaluation and the tasks it
+// spawns, which share its limits. Its counters are updated atomically,
+// as tasks run concurrently.
+type budget struct {
+	steps    atomic.Int64
+	elements atomic.Int64
+	bindings atomic.Int64
+	tasks    atomic.Int64
+}
+
+func newControl(ctx context.Context, limits Limits, interpreter *Interpreter) *control {
+	return &control{ctx: ctx, limits: limits, interpreter: interpreter, budget: &budget{}}
+}
+
+// task returns the control of a task spawned by the evaluation, which
+// has its own depth but is charged to the evaluation's budget.
+func (c *control) task() *control {
+	if tasks := c.tasks.Add(1); c.limits.Tasks > 0 && tasks > int64(c.limits.Tasks) {
+		panic(ExhaustedError{"tasks", c.limits.Tasks})
+	}
+	return &control{ctx: c.ctx, limits: c.limits, interpreter: c.interpreter, budget: c.budget}
+}
+
+// step accounts for one reduction, aborting the evaluation when the
+// context is done or the step budget is spent. The context is only
+// polled every so often as checking it is comparatively expensive.
+func (c *control) step() {
+	steps := c.steps.Add(1)
+	if steps&0xff == 1 {
+		c.check()
+	}
+	if c.limits.Steps > 0 && steps > int64(c.limits.Steps) {
+		panic(ExhaustedError{"steps", c.limits.Steps})
+	}
+}
+
+// check aborts the evaluation if its context is done.
+func (c *control) check() {
+	if err := c.ctx.Err(); err != nil {
+		panic(CanceledError{err})
+	}
+}
+
+func (c *control) enter() {
+	if depth := c.depth.Add(1); c.limits.Depth > 0 && depth > int64(c.limits.Depth) {
+		panic(ExhaustedError{"depth", c.limits.Depth})
+	}
+}
+
+func (c *control) leave() {
+	c.depth.Add(-1)
+}
+
+// allocate charges n elements to the evaluation.
+func (c *control) allocate(n int) {
+	if elements := c.elements.Add(int64(n)); c.limits.Elements > 0 && elements > int64(c.limits.Elements) {
+		panic(ExhaustedError{"elements", c.limits.Elements})
+	}
+}
+
+// bind charges n variable bindings to the evaluation.
+func (c *control) bind(n int) {
+	if bindings := c.bindings.Add(int64(n)); c.limits.Bindings > 0 && bindings > int64(c.limits.Bindings) {
+		panic(ExhaustedError{"bindings", c.limits.Bindings})
+	}
+}
+
+// measure checks the size of a number built by the evaluation.
+func (c *control) measure(value *big.Rat) {
+	c.measureBits(value.Num().BitLen() + value.Denom().BitLen())
+}
+
+// measureBits checks the size in bits of a number built by the
+// evaluation.
+func (c *control) measureBits(bits int) {
+	if c.limits.Bits > 0 && bits > c.limits.Bits {
+		panic(ExhaustedError{"bits", c.limits.Bits})
+	}
+}
+
+// controlledEnvironment attaches a control to an environment; all
+// bindings are delegated to the wrapped environment.
+type controlledEnvironment struct {
+	Environment
+	control *control
+}
+
+func (environment *controlledEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *controlledEnvironment) wrapped() Environment {
+	return environment.Environment
+}
+
+// wrappedEnvironment is implemented by the environments that mark an
+// evaluation and delegate all bindings to the environment they wrap.
+type wrappedEnvironment interface {
+	Environment
+	wrapped() Environment
+}
+
+// controlOf returns the control of the evaluation environment is part
+// of, or nil if the evaluation is not controlled.
+func controlOf(environment Environment) *control {
+	for ; environment != nil; environment = outer(environment) {
+		if e, ok := environment.(*controlledEnvironment); ok {
+			return e.control
+		}
+	}
+	return nil
+}
+
+// outer returns the environment that evaluations in environment are part
+// of: the caller of a NestedEnvironment made by an application, the
+// parent of any other NestedEnvironment, the environment a closure was
+// forced in, or the environment wrapped by one that marks an evaluation.
+// It returns nil for controlled environments, which start an evaluation,
+// and for other environments.
+func outer(environment Environment) Environment {
+	switch e := environment.(type) {
+	case *NestedEnvironment:
+		if e.caller != nil {
+			return e.caller
+		}
+		return e.Parent
+	case *forcedEnvironment:
+		return e.caller
+	case *controlledEnvironment:
+		return nil
+	case wrappedEnvironment:
+		return e.wrapped()
+	}
+	return nil
+}
+
+// EvaluateContext evaluates term like GuardedEvaluate, but gives up with a
+// CanceledError once ctx is done.
+func EvaluateContext(ctx context.Context, environment Environment, term Term) Term {
+	return EvaluateLimited(ctx, environment, term, Limits{})
+}
+
+// EvaluateLimited is like EvaluateContext, and additionally gives up with
+// an ExhaustedError once the evaluation exceeds limits.
+func EvaluateLimited(ctx context.Context, environment Environment, term Term, limits Limits) Term {
+	c := newControl(ctx, limits, nil)
+	return GuardedEvaluate(&controlledEnvironment{environment, c}, term)
+}
@@ -0,0 +1,348 @@
+package hu
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+var (
+	termType  = reflect.TypeOf((*Term)(nil)).Elem()
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	ratType   = reflect.TypeOf((*big.Rat)(nil))
+	intType   = reflect.TypeOf((*big.Int)(nil))
+)
+
+// ToTerm converts a Go value to the corresponding term: numbers to
+// Number, strings to String, bools to Boolean, slices and arrays to Tuple,
+// maps with string keys and structs to Record, and nil to nil. Terms are
+// returned as they are. A value that refers to itself is an error.
+func ToTerm(value interface{}) (Term, error) {
+	return valueToTerm(reflect.ValueOf(value))
+}
+
+// FromTerm stores term in the value pointed to by v, converting it as
+// ToTerm would in reverse. A term stored in an empty interface becomes
+// a *big.Rat, string, bool, []interface{} or map[string]interface{};
+// other terms are stored as they are.
+func FromTerm(term Term, v interface{}) error {
+	pointer := reflect.ValueOf(v)
+	if pointer.Kind() != reflect.Ptr || pointer.IsNil() {
+		return errors.New("hu: FromTerm requires a non-nil pointer")
+	}
+	value, err := termToValue(term, pointer.Type().Elem())
+	if err != nil {
+		return err
+	}
+	pointer.Elem().Set(value)
+	return nil
+}
+
+// valueToTerm converts value to a term as described for ToTerm, or
+// returns an error if value refers to itself.
+func valueToTerm(value reflect.Value) (Term, error) {
+	return (&converter{path: make(map[visit]bool)}).term(value)
+}
+
+// converter converts Go values to terms. If allocate is not nil, it is
+// charged for the elements of each tuple and record before it is built.
+type converter struct {
+	// path holds the pointers, maps and slices being converted.
+	path     map[visit]bool
+	allocate func(n int)
+}
+
+// visit identifies a pointer, map or slice being converted, so that one
+// found again inside itself is reported as a cycle.
+type visit struct {
+	pointer uintptr
+	typ     reflect.Type
+	length  int
+}
+
+func (c *converter) term(value reflect.Value) (Term, error) {
+	if !value.IsValid() {
+		return nil, nil
+	}
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !value.IsNil() {
+			v := visit{value.Pointer(), value.Type(), 0}
+			if value.Kind() == reflect.Slice {
+				v.length = value.Len()
+			}
+			if c.path[v] {
+				return nil, fmt.Errorf("cannot convert %v that refers to itself", value.Type())
+			}
+			c.path[v] = true
+			defer delete(c.path, v)
+		}
+	}
+	if value.Type().Implements(termType) {
+		if value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil, nil
+			}
+		}
+		return value.Interface().(Term), nil
+	}
+	switch value.Type() {
+	case ratType:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return &Number{new(big.Rat).Set(value.Interface().(*big.Rat))}, nil
+	case intType:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return &Number{new(big.Rat).SetInt(value.Interface().(*big.Int))}, nil
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		return Boolean(value.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Number{big.NewRat(value.Int(), 1)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Number{new(big.Rat).SetInt(new(big.Int).SetUint64(value.Uint()))}, nil
+	case reflect.Float32, reflect.Float64:
+		rat, ok := new(big.Rat).SetString(strconv.FormatFloat(value.Float(), 'g', -1, value.Type().Bits()))
+		if !ok {
+			return nil, fmt.Errorf("cannot convert %v to a number", value.Float())
+		}
+		return &Number{rat}, nil
+	case reflect.String:
+		return String(value.String()), nil
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return Tuple(nil), nil
+		}
+		c.charge(value.Len())
+		tuple := make(Tuple, value.Len())
+		for i := range tuple {
+			term, err := c.term(value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			tuple[i] = term
+		}
+		return tuple, nil
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %v to a record", value.Type())
+		}
+		c.charge(value.Len())
+		record := make(Record, value.Len())
+		iterator := value.MapRange()
+		for iterator.Next() {
+			term, err := c.term(iterator.Value())
+			if err != nil {
+				return nil, err
+			}
+			record[Symbol(iterator.Key().String())] = term
+		}
+		return record, nil
+	case reflect.Struct:
+		fields := reflect.VisibleFields(value.Type())
+		c.charge(len(fields))
+		record := make(Record)
+		for _, field := range fields {
+			name, ok := fieldName(field)
+			if !ok {
+				continue
+			}
+			term, err := c.term(value.FieldByIndex(field.Index))
+			if err != nil {
+				return nil, err
+			}
+			record[name] = term
+		}
+		return record, nil
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return c.term(value.Elem())
+	}
+	return nil, fmt.Errorf("cannot convert %v to a term", value.Type())
+}
+
+func (c *converter) charge(n int) {
+	if c.allocate != nil {
+		c.allocate(n)
+	}
+}
+
+// termToValue converts term to a Go value of type typ as described for
+// FromTerm.
+func termToValue(term Term, typ reflect.Type) (reflect.Value, error) {
+	if term == nil {
+		return reflect.Zero(typ), nil
+	}
+	switch {
+	case typ.Kind() == reflect.Interface && typ.NumMethod() == 0:
+		// converted to a natural Go value below
+	case reflect.TypeOf(term).AssignableTo(typ):
+		return reflect.ValueOf(term), nil
+	}
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %v to %v", term, typ)
+	}
+	switch typ {
+	case ratType:
+		if n, ok := term.(*Number); ok {
+			return reflect.ValueOf(new(big.Rat).Set(n.value)), nil
+		}
+		return mismatch()
+	case intType:
+		if n, ok := term.(*Number); ok && n.value.IsInt() {
+			return reflect.ValueOf(new(big.Int).Set(n.value.Num())), nil
+		}
+		return mismatch()
+	}
+	value := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.Bool:
+		b, ok := term.(Boolean)
+		if !ok {
+			return mismatch()
+		}
+		value.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := term.(*Number)
+		if !ok || !n.value.IsInt() || !n.value.Num().IsInt64() || value.OverflowInt(n.value.Num().Int64()) {
+			return mismatch()
+		}
+		value.SetInt(n.value.Num().Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := term.(*Number)
+		if !ok || !n.value.IsInt() || !n.value.Num().IsUint64() || value.OverflowUint(n.value.Num().Uint64()) {
+			return mismatch()
+		}
+		value.SetUint(n.value.Num().Uint64())
+	case reflect.Float32, reflect.Float64:
+		n, ok := term.(*Number)
+		if !ok {
+			return mismatch()
+		}
+		f, _ := n.value.Float64()
+		if math.IsInf(f, 0) || value.OverflowFloat(f) {
+			return mismatch()
+		}
+		value.SetFloat(f)
+	case reflect.String:
+		switch s := term.(type) {
+		case String:
+			value.SetString(string(s))
+		case Symbol:
+			value.SetString(string(s))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice, reflect.Array:
+		tuple, ok := term.(Tuple)
+		if !ok {
+			return mismatch()
+		}
+		if typ.Kind() == reflect.Slice {
+			value.Set(reflect.MakeSlice(typ, len(tuple), len(tuple)))
+		} else if len(tuple) != typ.Len() {
+			return mismatch()
+		}
+		for i, t := range tuple {
+			element, err := termToValue(t, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value.Index(i).Set(element)
+		}
+	case reflect.Map:
+		record, ok := term.(Record)
+		if !ok || typ.Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		value.Set(reflect.MakeMapWithSize(typ, len(record)))
+		for name, t := range record {
+			element, err := termToValue(t, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value.SetMapIndex(reflect.ValueOf(string(name)).Convert(typ.Key()), element)
+		}
+	case reflect.Struct:
+		record, ok := term.(Record)
+		if !ok {
+			return mismatch()
+		}
+		for _, field := range reflect.VisibleFields(typ) {
+			name, ok := fieldName(field)
+			if !ok {
+				continue
+			}
+			t, ok := record[name]
+			if !ok {
+				continue
+			}
+			element, err := termToValue(t, field.Type)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value.FieldByIndex(field.Index).Set(element)
+		}
+	case reflect.Ptr:
+		element, err := termToValue(term, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.Set(reflect.New(typ.Elem()))
+		value.Elem().Set(element)
+	case reflect.Interface:
+		if typ.NumMethod() != 0 {
+			return mismatch()
+		}
+		var natural reflect.Type
+		switch term.(type) {
+		case *Number:
+			natural = ratType
+		case String:
+			natural = reflect.TypeOf("")
+		case Boolean:
+			natural = reflect.TypeOf(true)
+		case Tuple:
+			natural = reflect.TypeOf([]interface{}{})
+		case Record:
+			natural = reflect.TypeOf(map[string]interface{}{})
+		default:
+			value.Set(reflect.ValueOf(term))
+			return value, nil
+		}
+		element, err := termToValue(term, natural)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.Set(element)
+	default:
+		return mismatch()
+	}
+	return value, nil
+}
+
+// fieldName returns the record field name for a struct field: the name
+// given by a hu tag, or else the name of the field. Unexported fields and
+// those tagged "-" are skipped.
+func fieldName(field reflect.StructField) (Symbol, bool) {
+	if !field.IsExported() || field.Anonymous {
+		return "", false
+	}
+	switch tag := field.Tag.Get("hu"); tag {
+	case "-":
+		return "", false
+	case "":
+		return Symbol(field.Name), true
+	default:
+		return Symbol(tag), true
+	}
+}
@@ -0,0 +1,109 @@
+package hu
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	type point struct {
+		X, Y int
+		Name string `hu:"name"`
+	}
+	values := []interface{}{
+		int64(-7),
+		uint8(200),
+		2.5,
+		"hu",
+		true,
+		[]int{1, 2, 3},
+		[2]string{"a", "b"},
+		map[string]bool{"yes": true, "no": false},
+		point{1, 2, "origin"},
+		&point{3, 4, "p"},
+		big.NewRat(1, 3),
+	}
+	for _, value := range values {
+		term, err := ToTerm(value)
+		if err != nil {
+			t.Errorf("ToTerm(%#v): %v", value, err)
+			continue
+		}
+		result := reflect.New(reflect.TypeOf(value))
+		if err := FromTerm(term, result.Interface()); err != nil {
+			t.Errorf("FromTerm(%v): %v", term, err)
+			continue
+		}
+		if !reflect.DeepEqual(result.Elem().Interface(), value) {
+			t.Errorf("%#v round tripped through %v to %#v", value, term, result.Elem().Interface())
+		}
+	}
+
+	var decoded interface{}
+	term, _ := ToTerm([]interface{}{1, "two", map[string]int{"three": 3}})
+	if err := FromTerm(term, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{big.NewRat(1, 1), "two", map[string]interface{}{"three": big.NewRat(3, 1)}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("FromTerm into interface: got %#v", decoded)
+	}
+
+	var small int8
+	if err := FromTerm(NewNumber(big.NewRat(300, 1)), &small); err == nil {
+		t.Errorf("expected an overflow error")
+	}
+	var whole int
+	if err := FromTerm(NewNumber(big.NewRat(1, 2)), &whole); err == nil {
+		t.Errorf("expected an error converting 1/2 to int")
+	}
+	if err := FromTerm(String("x"), whole); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+	if _, err := ToTerm(make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel")
+	}
+	var single float32
+	if err := FromTerm(NewNumber(new(big.Rat).SetFloat64(1e300)), &single); err == nil {
+		t.Errorf("expected an overflow error converting 1e300 to float32, got %v", single)
+	}
+
+	type node struct {
+		Next *node
+	}
+	var n node
+	n.Next = &n
+	if _, err := ToTerm(&n); err == nil {
+		t.Errorf("expected an error converting a pointer cycle")
+	}
+	m := map[string]interface{}{}
+	m["self"] = m
+	if _, err := ToTerm(m); err == nil {
+		t.Errorf("expected an error converting a map cycle")
+	}
+	shared := &point{5, 6, "shared"}
+	if _, err := ToTerm([]*point{shared, shared}); err != nil {
+		t.Errorf("a value referred to twice is not a cycle: %v", err)
+	}
+}
+
+func TestNumber(t *testing.T) {
+	n := NewNumber(big.NewRat(6, 4))
+	if n.String() != "3/2" {
+		t.Errorf("expected 3/2, got %v", n)
+	}
+	if _, ok := n.Int64(); ok {
+		t.Errorf("3/2 is not an integer")
+	}
+	if f, exact := n.Float64(); f != 1.5 || !exact {
+		t.Errorf("expected exactly 1.5, got %v %v", f, exact)
+	}
+	n.Rat().SetInt64(0)
+	if n.String() != "3/2" {
+		t.Errorf("Rat exposed the value of n")
+	}
+	if i, ok := NewNumber(big.NewRat(-12, 1)).Int64(); i != -12 || !ok {
+		t.Errorf("expected -12, got %v %v", i, ok)
+	}
+}
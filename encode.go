@@ -0,0 +1,711 @@
+package hu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// The binary encoding of terms and environments starts with a header
+// holding magic and a version. Each value is then written as a tag byte
+// followed by its contents. Environments and properties are written in
+// full the first time they are encountered and by reference afterwards,
+// so sharing and cycles survive a round trip. Abstractions and closures
+// are written with their environments, and properties with their
+// expression, environment, value and observers; computed values are
+// recomputed after decoding. Primitives are written by name, so they must
+// be registered. Observers added from Go are not encoded, and channels,
+// futures and continuations, which belong to the running process, cannot
+// be: encoding one is an error naming the binding that holds it.
+const (
+	magic   = "hu\x00"
+	version = 1
+)
+
+const (
+	tagNil byte = iota
+	tagReference
+	tagTrue
+	tagFalse
+	tagRune
+	tagNumber
+	tagSymbol
+	tagString
+	tagTuple
+	tagSet
+	tagPart
+	tagRecord
+	tagApplication
+	tagPrimitiveFunction
+	tagPrimitive
+	tagGoFunction
+	tagAbstraction
+	tagClosure
+	tagProperty
+	tagError
+	tagUnboundVariableError
+	tagCanceledError
+	tagExhaustedError
+	tagLocalEnvironment
+	tagNestedEnvironment
+	tagSyncEnvironment
+	tagPersistentEnvironment
+	tagRaisedError
+	tagMacro
+	tagQuote
+)
+
+// A Registry names the primitives that may be encoded, as functions are
+// written by name rather than by value.
+type Registry struct {
+	names     map[uintptr]string
+	functions map[string]Term
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[uintptr]string), functions: make(map[string]Term)}
+}
+
+// DefaultRegistry returns a registry of the primitives bound by
+// AddDefaultBindings.
+func DefaultRegistry() *Registry {
+	registry := NewRegistry()
+	registry.AddPrimitives(DefaultPrimitives()...)
+	return registry
+}
+
+// Register registers function, which must be a PrimitiveFunction,
+// Primitive or GoFunction, under name.
+func (registry *Registry) Register(name string, function Term) {
+	switch f := function.(type) {
+	case PrimitiveFunction, Primitive:
+		registry.names[reflect.ValueOf(f).Pointer()] = name
+	case GoFunction:
+	default:
+		panic(fmt.Sprintf("cannot register %v", function))
+	}
+	registry.functions[name] = function
+}
+
+// AddPrimitives registers the primitives in sets under the names they
+// are bound to.
+func (registry *Registry) AddPrimitives(sets ...PrimitiveSet) {
+	environment := make(LocalEnvironment)
+	AddPrimitives(environment, sets...)
+	for name, value := range environment {
+		switch value.(type) {
+		case PrimitiveFunction, Primitive, GoFunction:
+			registry.Register(string(name), value)
+		}
+	}
+}
+
+func (registry *Registry) name(function Term) (string, error) {
+	if f, ok := function.(GoFunction); ok {
+		if _, ok := registry.functions[f.Name]; ok {
+			return f.Name, nil
+		}
+	} else if name, ok := registry.names[reflect.ValueOf(function).Pointer()]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("%v is not registered", function)
+}
+
+// An Encoder writes terms and environments to a stream.
+type Encoder struct {
+	w        *bufio.Writer
+	registry *Registry
+	ids      map[interface{}]uint64
+	started  bool
+}
+
+func NewEncoder(w io.Writer, registry *Registry) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), registry: registry, ids: make(map[interface{}]uint64)}
+}
+
+func (encoder *Encoder) Encode(term Term) error {
+	return encoder.encode(func() error { return encoder.term(term) })
+}
+
+func (encoder *Encoder) EncodeEnvironment(environment Environment) error {
+	return encoder.encode(func() error { return encoder.environment(environment) })
+}
+
+func (encoder *Encoder) encode(f func() error) error {
+	if !encoder.started {
+		encoder.w.WriteString(magic)
+		encoder.w.WriteByte(version)
+		encoder.started = true
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return encoder.w.Flush()
+}
+
+func (encoder *Encoder) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	encoder.w.Write(b[:binary.PutUvarint(b[:], x)])
+}
+
+func (encoder *Encoder) string(s string) {
+	encoder.uvarint(uint64(len(s)))
+	encoder.w.WriteString(s)
+}
+
+func (encoder *Encoder) terms(tag byte, terms []Term) error {
+	encoder.w.WriteByte(tag)
+	encoder.uvarint(uint64(len(terms)))
+	for _, term := range terms {
+		if err := encoder.term(term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shared writes a reference and returns false if key has already been
+// written; otherwise it writes tag and assigns key the next id.
+func (encoder *Encoder) shared(key interface{}, tag byte) bool {
+	if id, ok := encoder.ids[key]; ok {
+		encoder.w.WriteByte(tagReference)
+		encoder.uvarint(id)
+		return false
+	}
+	encoder.ids[key] = uint64(len(encoder.ids))
+	encoder.w.WriteByte(tag)
+	return true
+}
+
+func (encoder *Encoder) term(term Term) error {
+	switch t := term.(type) {
+	case nil:
+		encoder.w.WriteByte(tagNil)
+	case Boolean:
+		if t {
+			encoder.w.WriteByte(tagTrue)
+		} else {
+			encoder.w.WriteByte(tagFalse)
+		}
+	case Rune:
+		encoder.w.WriteByte(tagRune)
+		encoder.uvarint(uint64(t))
+	case *Number:
+		encoder.w.WriteByte(tagNumber)
+		encoder.string(t.value.RatString())
+	case Symbol:
+		encoder.w.WriteByte(tagSymbol)
+		encoder.string(string(t))
+	case String:
+		encoder.w.WriteByte(tagString)
+		encoder.string(string(t))
+	case Error:
+		encoder.w.WriteByte(tagError)
+		encoder.string(string(t))
+	case Tuple:
+		return encoder.terms(tagTuple, t)
+	case Set:
+		return encoder.terms(tagSet, t)
+	case Part:
+		return encoder.terms(tagPart, t)
+	case Application:
+		return encoder.terms(tagApplication, t)
+	case Record:
+		encoder.w.WriteByte(tagRecord)
+		return encoder.bindings(t)
+	case PrimitiveFunction, Primitive, GoFunction:
+		name, err := encoder.registry.name(t)
+		if err != nil {
+			return err
+		}
+		switch t.(type) {
+		case PrimitiveFunction:
+			encoder.w.WriteByte(tagPrimitiveFunction)
+		case Primitive:
+			encoder.w.WriteByte(tagPrimitive)
+		default:
+			encoder.w.WriteByte(tagGoFunction)
+		}
+		encoder.string(name)
+	case Abstraction:
+		encoder.w.WriteByte(tagAbstraction)
+		if err := encoder.term(t.Parameters); err != nil {
+			return err
+		}
+		if err := encoder.term(t.Term); err != nil {
+			return err
+		}
+		return encoder.environment(t.Environment)
+	case Quote:
+		encoder.w.WriteByte(tagQuote)
+		return encoder.term(t.Term)
+	case Macro:
+		encoder.w.WriteByte(tagMacro)
+		if err := encoder.term(t.Literals); err != nil {
+			return err
+		}
+		return encoder.term(t.Rules)
+	case Closure:
+		encoder.w.WriteByte(tagClosure)
+		if err := encoder.term(t.Term); err != nil {
+			return err
+		}
+		return encoder.environment(t.Environment)
+	case *Property:
+		if encoder.shared(t, tagProperty) {
+			encoder.string(string(t.Name))
+			if err := encoder.term(t.DidSet); err != nil {
+				return err
+			}
+			if err := encoder.term(t.Expression); err != nil {
+				return err
+			}
+			if err := encoder.environment(t.Environment); err != nil {
+				return err
+			}
+			// Computed values are recomputed after decoding.
+			t.mutex.Lock()
+			value, next := t.value, t.next
+			var observers []observer
+			for _, o := range t.observers {
+				if o.function == nil {
+					observers = append(observers, o)
+				}
+			}
+			t.mutex.Unlock()
+			if t.computed() {
+				value = nil
+			}
+			if err := encoder.term(value); err != nil {
+				return err
+			}
+			encoder.uvarint(uint64(next))
+			encoder.uvarint(uint64(len(observers)))
+			for _, o := range observers {
+				encoder.uvarint(uint64(o.id))
+				encoder.term(Boolean(o.willSet))
+				if err := encoder.term(o.handler); err != nil {
+					return err
+				}
+			}
+		}
+	case UnboundVariableError:
+		encoder.w.WriteByte(tagUnboundVariableError)
+		encoder.string(t.operation)
+		return encoder.term(t.variable)
+	case CanceledError:
+		encoder.w.WriteByte(tagCanceledError)
+		encoder.string(t.err.Error())
+	case ExhaustedError:
+		encoder.w.WriteByte(tagExhaustedError)
+		encoder.string(t.resource)
+		encoder.uvarint(uint64(t.limit))
+	case RaisedError:
+		encoder.w.WriteByte(tagRaisedError)
+		encoder.string(string(t.kind))
+		return encoder.term(t.value)
+	case Environment:
+		return encoder.environment(t)
+	case *Channel, *Future, *Continuation:
+		return fmt.Errorf("cannot encode %v", term)
+	default:
+		return fmt.Errorf("cannot encode %T", term)
+	}
+	return nil
+}
+
+func (encoder *Encoder) environment(environment Environment) error {
+	switch e := environment.(type) {
+	case nil:
+		encoder.w.WriteByte(tagNil)
+	case *LocalEnvironment:
+		return encoder.environment(*e)
+	case LocalEnvironment:
+		if encoder.shared(reflect.ValueOf(e).Pointer(), tagLocalEnvironment) {
+			return encoder.bindings(e)
+		}
+	case *NestedEnvironment:
+		if encoder.shared(e, tagNestedEnvironment) {
+			if err := encoder.environment(e.Environment); err != nil {
+				return err
+			}
+			return encoder.environment(e.Parent)
+		}
+	case *SyncEnvironment:
+		if encoder.shared(e, tagSyncEnvironment) {
+			return encoder.bindings(e.snapshot())
+		}
+	case *PersistentEnvironment:
+		if encoder.shared(e, tagPersistentEnvironment) {
+			return encoder.bindings(e.snapshot())
+		}
+	case wrappedEnvironment:
+		return encoder.environment(e.wrapped())
+	default:
+		return fmt.Errorf("cannot encode %T", environment)
+	}
+	return nil
+}
+
+func (encoder *Encoder) bindings(bindings map[Symbol]Term) error {
+	encoder.uvarint(uint64(len(bindings)))
+	for _, name := range sortedNames(bindings) {
+		encoder.string(string(name))
+		if err := encoder.term(bindings[name]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// A Decoder reads terms and environments written by an Encoder.
+type Decoder struct {
+	r        *bufio.Reader
+	registry *Registry
+	objects  []interface{}
+	started  bool
+}
+
+func NewDecoder(r io.Reader, registry *Registry) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), registry: registry}
+}
+
+func (decoder *Decoder) Decode() (Term, error) {
+	if err := decoder.start(); err != nil {
+		return nil, err
+	}
+	term, err := decoder.term()
+	if err == nil {
+		decoder.recompute()
+	}
+	return term, err
+}
+
+func (decoder *Decoder) DecodeEnvironment() (Environment, error) {
+	if err := decoder.start(); err != nil {
+		return nil, err
+	}
+	term, err := decoder.term()
+	if err != nil {
+		return nil, err
+	}
+	decoder.recompute()
+	environment, ok := term.(Environment)
+	if !ok && term != nil {
+		return nil, fmt.Errorf("decoded %T rather than an environment", term)
+	}
+	return environment, nil
+}
+
+// recompute computes the observed computed properties decoded so far, so
+// that their sources know to recompute them.
+func (decoder *Decoder) recompute() {
+	for _, object := range decoder.objects {
+		if property, ok := object.(*Property); ok && property.computed() && property.observed() {
+			GuardedEvaluate(property.Environment, Primitive(property.get))
+		}
+	}
+}
+
+func (decoder *Decoder) start() error {
+	if decoder.started {
+		return nil
+	}
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(decoder.r, header); err != nil {
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return errors.New("not a hu encoding")
+	}
+	if header[len(magic)] != version {
+		return fmt.Errorf("unsupported encoding version %d", header[len(magic)])
+	}
+	decoder.started = true
+	return nil
+}
+
+func (decoder *Decoder) uvarint() (uint64, error) {
+	return binary.ReadUvarint(decoder.r)
+}
+
+func (decoder *Decoder) string() (string, error) {
+	n, err := decoder.uvarint()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	_, err = io.CopyN(&b, decoder.r, int64(n))
+	return b.String(), err
+}
+
+func (decoder *Decoder) terms() ([]Term, error) {
+	n, err := decoder.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	var terms []Term
+	for i := uint64(0); i < n; i++ {
+		term, err := decoder.term()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+func (decoder *Decoder) environment() (Environment, error) {
+	term, err := decoder.term()
+	if err != nil || term == nil {
+		return nil, err
+	}
+	environment, ok := term.(Environment)
+	if !ok {
+		return nil, fmt.Errorf("decoded %T rather than an environment", term)
+	}
+	return environment, nil
+}
+
+func (decoder *Decoder) term() (Term, error) {
+	tag, err := decoder.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagReference:
+		id, err := decoder.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if id >= uint64(len(decoder.objects)) {
+			return nil, fmt.Errorf("invalid reference %d", id)
+		}
+		return decoder.objects[id].(Term), nil
+	case tagTrue:
+		return Boolean(true), nil
+	case tagFalse:
+		return Boolean(false), nil
+	case tagRune:
+		r, err := decoder.uvarint()
+		return Rune(r), err
+	case tagNumber, tagSymbol, tagString, tagError, tagPrimitiveFunction, tagPrimitive, tagGoFunction:
+		s, err := decoder.string()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagNumber:
+			value, ok := new(big.Rat).SetString(s)
+			if !ok {
+				return nil, fmt.Errorf("invalid number %q", s)
+			}
+			return &Number{value}, nil
+		case tagSymbol:
+			return Symbol(s), nil
+		case tagString:
+			return String(s), nil
+		case tagError:
+			return Error(s), nil
+		}
+		function, ok := decoder.registry.functions[s]
+		if !ok {
+			return nil, fmt.Errorf("primitive %s is not registered", s)
+		}
+		return function, nil
+	case tagTuple, tagSet, tagPart, tagApplication:
+		terms, err := decoder.terms()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagTuple:
+			return Tuple(terms), nil
+		case tagSet:
+			return Set(terms), nil
+		case tagPart:
+			return Part(terms), nil
+		}
+		return Application(terms), nil
+	case tagRecord:
+		record := make(Record)
+		if err := decoder.bindings(func(name Symbol, value Term) { record[name] = value }); err != nil {
+			return nil, err
+		}
+		return record, nil
+	case tagAbstraction:
+		parameters, err := decoder.term()
+		if err != nil {
+			return nil, err
+		}
+		term, err := decoder.term()
+		if err != nil {
+			return nil, err
+		}
+		environment, err := decoder.environment()
+		return Abstraction{parameters, term, environment}, err
+	case tagQuote:
+		term, err := decoder.term()
+		return Quote{term}, err
+	case tagMacro:
+		var tuples [2]Tuple
+		for i := range tuples {
+			term, err := decoder.term()
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if tuples[i], ok = term.(Tuple); !ok {
+				return nil, fmt.Errorf("decoded %T rather than a tuple", term)
+			}
+		}
+		return Macro{tuples[0], tuples[1]}, nil
+	case tagClosure:
+		term, err := decoder.term()
+		if err != nil {
+			return nil, err
+		}
+		environment, err := decoder.environment()
+		return Closure{term, environment}, err
+	case tagProperty:
+		property := &Property{}
+		decoder.objects = append(decoder.objects, property)
+		name, err := decoder.string()
+		if err != nil {
+			return nil, err
+		}
+		property.Name = Symbol(name)
+		didSet, err := decoder.term()
+		if err != nil {
+			return nil, err
+		}
+		if property.DidSet, err = asAbstraction(didSet); err != nil {
+			return nil, err
+		}
+		if property.Expression, err = decoder.term(); err != nil {
+			return nil, err
+		}
+		if property.Environment, err = decoder.environment(); err != nil {
+			return nil, err
+		}
+		if property.value, err = decoder.term(); err != nil {
+			return nil, err
+		}
+		property.dirty = property.computed()
+		next, err := decoder.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		property.next = int(next)
+		n, err := decoder.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		for ; n > 0; n-- {
+			id, err := decoder.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			willSet, err := decoder.term()
+			if err != nil {
+				return nil, err
+			}
+			handler, err := decoder.term()
+			if err != nil {
+				return nil, err
+			}
+			property.observers = append(property.observers, observer{id: int(id), willSet: willSet == Boolean(true), handler: handler})
+		}
+		return property, nil
+	case tagUnboundVariableError:
+		operation, err := decoder.string()
+		if err != nil {
+			return nil, err
+		}
+		variable, err := decoder.term()
+		return UnboundVariableError{variable, operation}, err
+	case tagCanceledError:
+		s, err := decoder.string()
+		return CanceledError{errors.New(s)}, err
+	case tagExhaustedError:
+		resource, err := decoder.string()
+		if err != nil {
+			return nil, err
+		}
+		limit, err := decoder.uvarint()
+		return ExhaustedError{resource, int(limit)}, err
+	case tagRaisedError:
+		kind, err := decoder.string()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decoder.term()
+		return RaisedError{Symbol(kind), value}, err
+	case tagLocalEnvironment:
+		environment := make(LocalEnvironment)
+		decoder.objects = append(decoder.objects, environment)
+		if err := decoder.bindings(environment.Define); err != nil {
+			return nil, err
+		}
+		return environment, nil
+	case tagSyncEnvironment:
+		environment := NewSyncEnvironment()
+		decoder.objects = append(decoder.objects, environment)
+		if err := decoder.bindings(environment.Define); err != nil {
+			return nil, err
+		}
+		return environment, nil
+	case tagPersistentEnvironment:
+		environment := NewPersistentEnvironment()
+		decoder.objects = append(decoder.objects, environment)
+		if err := decoder.bindings(environment.Define); err != nil {
+			return nil, err
+		}
+		return environment, nil
+	case tagNestedEnvironment:
+		environment := &NestedEnvironment{}
+		decoder.objects = append(decoder.objects, environment)
+		if environment.Environment, err = decoder.environment(); err != nil {
+			return nil, err
+		}
+		if environment.Parent, err = decoder.environment(); err != nil {
+			return nil, err
+		}
+		return environment, nil
+	}
+	return nil, fmt.Errorf("invalid tag %d", tag)
+}
+
+func (decoder *Decoder) bindings(define func(Symbol, Term)) error {
+	n, err := decoder.uvarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		name, err := decoder.string()
+		if err != nil {
+			return err
+		}
+		value, err := decoder.term()
+		if err != nil {
+			return err
+		}
+		define(Symbol(name), value)
+	}
+	return nil
+}
+
+func asAbstraction(term Term) (Abstraction, error) {
+	abstraction, ok := term.(Abstraction)
+	if !ok {
+		return Abstraction{}, fmt.Errorf("decoded %T rather than an abstraction", term)
+	}
+	return abstraction, nil
+}
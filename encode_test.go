@@ -0,0 +1,101 @@
+package hu
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	environment := make(LocalEnvironment)
+	AddDefaultBindings(environment)
+	BindGo(environment, "shout", strings.ToUpper)
+	for _, input := range []string{
+		"{define (double (x)) {+ x x}}",
+		"{define numbers (1 2/3 \"four\" (true false))}",
+		"{variable schedule {lambda (s) s}}",
+		"{define (adder (n)) {lambda (x) {+ x n}}}",
+		"{begin {define add3 nil} {set add3 {adder 3}}}",
+		"{begin {variable price} {set price 3} {computed total {* price 2}}}",
+		"{begin {define doubled 0} {observe total {lambda (new old) {set doubled new}}}}",
+		"{define code '{+ 1 2}}",
+		"{define-syntax unless {syntax-rules () ({_ test body ...} {if test false {begin body ...}})}}",
+	} {
+		GuardedEvaluate(environment, Read(strings.NewReader(input)))
+	}
+	nested := &NestedEnvironment{Environment: make(LocalEnvironment), Parent: environment}
+	nested.Define("self", Closure{Symbol("numbers"), nested})
+	environment.Define("nested", nested)
+	environment.Define("again", Closure{Symbol("numbers"), nested})
+	environment.Define("person", Record{"name": String("hu"), "age": NewNumber(big.NewRat(12, 1))})
+
+	registry := DefaultRegistry()
+	registry.Register("shout", environment["shout"])
+	var buffer bytes.Buffer
+	if err := NewEncoder(&buffer, registry).EncodeEnvironment(environment); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := NewDecoder(&buffer, registry).DecodeEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []testCase{
+		{"{double 4}", is_eq_number(8)},
+		{"{+ 1 2}", is_eq_number(3)},
+		{"numbers", is_tuple()},
+		{`{shout "hu"}`, is_eq(String("HU"))},
+		{"{field person age}", is_eq_number(12)},
+		{"again", is_tuple()},
+		{"{add3 4}", is_eq_number(7)},
+		{"total", is_eq_number(6)},
+		{"{begin {set price 5} total}", is_eq_number(10)},
+		{"doubled", is_eq_number(10)},
+		{"{unless false 1 2}", is_eq_number(2)},
+		{"{eval code}", is_eq_number(3)},
+	}
+	for _, test := range tests {
+		result := GuardedEvaluate(decoded, Read(strings.NewReader(test.input)))
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+
+	local := decoded.(LocalEnvironment)
+	n := local["nested"].(*NestedEnvironment)
+	if n.Parent.(LocalEnvironment)["nested"] != n {
+		t.Errorf("cycle through the parent environment was not preserved")
+	}
+	if result := GuardedEvaluate(n, Symbol("self")); !is_tuple()(result) {
+		t.Errorf("self unexpectedly resulted in %v", result)
+	}
+	if local["again"].(Closure).Environment != n {
+		t.Errorf("sharing of the nested environment was not preserved")
+	}
+	if property, ok := local["schedule"].(*Property); !ok || property.Name != "schedule" {
+		t.Errorf("expected the schedule property, got %v", local["schedule"])
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := NewEncoder(&buffer, NewRegistry()).Encode(PrimitiveFunction(lambda)); err == nil {
+		t.Errorf("expected an error encoding an unregistered primitive")
+	}
+	buffer.Reset()
+	NewEncoder(&buffer, DefaultRegistry()).Encode(PrimitiveFunction(lambda))
+	if _, err := NewDecoder(&buffer, NewRegistry()).Decode(); err == nil {
+		t.Errorf("expected an error decoding an unregistered primitive")
+	}
+	buffer.Reset()
+	if err := NewEncoder(&buffer, NewRegistry()).EncodeEnvironment(LocalEnvironment{"task": &Future{}}); err == nil || !strings.Contains(err.Error(), "task") {
+		t.Errorf("expected an error naming the binding of a future, got %v", err)
+	}
+	if _, err := NewDecoder(strings.NewReader("hu\x00\x63"), NewRegistry()).Decode(); err == nil {
+		t.Errorf("expected an error decoding a later version")
+	}
+	if _, err := NewDecoder(strings.NewReader("{}"), NewRegistry()).Decode(); err == nil {
+		t.Errorf("expected an error decoding something else")
+	}
+}
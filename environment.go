@@ -0,0 +1,92 @@
+package hu
+
+import "sync"
+
+// SyncEnvironment is an Environment that is safe for concurrent use by
+// multiple goroutines. Each Define, Set and Get is atomic, but a sequence
+// of them is not: two goroutines that both get and then set a variable
+// may lose an update.
+type SyncEnvironment struct {
+	mutex    sync.RWMutex
+	bindings map[Symbol]Term
+}
+
+func NewSyncEnvironment() *SyncEnvironment {
+	return &SyncEnvironment{bindings: make(map[Symbol]Term)}
+}
+
+func (environment *SyncEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *SyncEnvironment) Define(variable Symbol, value Term) {
+	environment.mutex.Lock()
+	defer environment.mutex.Unlock()
+	environment.bindings[variable] = value
+}
+
+func (environment *SyncEnvironment) Set(variable Symbol, value Term) bool {
+	environment.mutex.Lock()
+	defer environment.mutex.Unlock()
+	_, ok := environment.bindings[variable]
+	if ok {
+		environment.bindings[variable] = value
+	}
+	return ok
+}
+
+func (environment *SyncEnvironment) Get(variable Symbol) (Term, bool) {
+	environment.mutex.RLock()
+	defer environment.mutex.RUnlock()
+	value, ok := environment.bindings[variable]
+	if ok {
+		return value, ok
+	} else {
+		return UnboundVariableError{variable, "get"}, false
+	}
+}
+
+// safe reports whether the bindings made in environment itself are safe
+// for concurrent use.
+func safe(environment Environment) bool {
+	switch e := environment.(type) {
+	case *SyncEnvironment, *PersistentEnvironment:
+		return true
+	case *NestedEnvironment:
+		return safe(e.Environment)
+	case wrappedEnvironment:
+		return safe(e.wrapped())
+	}
+	return false
+}
+
+// concurrent reports whether the bindings of environment and of all its
+// parents are safe for concurrent use.
+func concurrent(environment Environment) bool {
+	for ; environment != nil; environment = Parent(environment) {
+		if !safe(environment) {
+			return false
+		}
+	}
+	return true
+}
+
+// newBindings returns the bindings for a new frame extending parent,
+// which are safe for concurrent use if those of parent are.
+func newBindings(parent Environment) Environment {
+	if safe(parent) {
+		return NewSyncEnvironment()
+	}
+	return make(LocalEnvironment)
+}
+
+// snapshot returns a copy of the bindings in environment.
+func (environment *SyncEnvironment) snapshot() map[Symbol]Term {
+	environment.mutex.RLock()
+	defer environment.mutex.RUnlock()
+	bindings := make(map[Symbol]Term, len(environment.bindings))
+	for name, value := range environment.bindings {
+		bindings[name] = value
+	}
+	return bindings
+}
@@ -0,0 +1,198 @@
+package hu
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSyncEnvironment(t *testing.T) {
+	environment := NewSyncEnvironment()
+	environment.Define("shared", nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			name := Symbol(fmt.Sprintf("v%d", g))
+			for i := 0; i < 1000; i++ {
+				environment.Define(name, NewNumber(big.NewRat(int64(i), 1)))
+				environment.Set("shared", NewNumber(big.NewRat(int64(i), 1)))
+				if _, ok := environment.Get(name); !ok {
+					t.Errorf("%s is unbound", name)
+				}
+				environment.Get("shared")
+			}
+		}(g)
+	}
+	wg.Wait()
+	for g := 0; g < 16; g++ {
+		if value, _ := environment.Get(Symbol(fmt.Sprintf("v%d", g))); !is_eq_number(999)(value) {
+			t.Errorf("v%d: expected 999, got %v", g, value)
+		}
+	}
+}
+
+func TestConcurrentInterpreter(t *testing.T) {
+	interpreter := NewInterpreter(WithEnvironment(NewSyncEnvironment()))
+	interpreter.Eval("{define last 0}\n{variable p {lambda (v) {set last v}}}")
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				program := fmt.Sprintf("{define x%d %d}\n{set p {+ x%d 1}}\n{+ last x%d}", g, i, g, g)
+				if result := interpreter.Eval(program); !is_number()(result) {
+					t.Errorf("%s unexpectedly resulted in %v", program, result)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if result := interpreter.Eval("{< 0 last 101}"); !is_eq(Boolean(true))(result) {
+		t.Errorf("expected last to be set by a didSet handler, got %v", interpreter.Eval("last"))
+	}
+
+	var buffer bytes.Buffer
+	registry := DefaultRegistry()
+	if err := NewEncoder(&buffer, registry).EncodeEnvironment(interpreter.Environment()); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := NewDecoder(&buffer, registry).DecodeEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := decoded.(*SyncEnvironment).Get("x15"); !is_eq_number(99)(value) {
+		t.Errorf("expected x15 to be 99 after a round trip, got %v", value)
+	}
+}
+
+func TestPersistentEnvironment(t *testing.T) {
+	environment := NewPersistentEnvironment()
+	reference := make(map[Symbol]Term)
+	for i := 0; i < 5000; i++ {
+		name := Symbol(fmt.Sprintf("v%d", i%3000))
+		value := NewNumber(big.NewRat(int64(i), 1))
+		environment.Define(name, value)
+		reference[name] = value
+	}
+	if bindings := environment.snapshot(); len(bindings) != len(reference) {
+		t.Errorf("expected %d bindings, got %d", len(reference), len(bindings))
+	}
+	for name, value := range reference {
+		if v, ok := environment.Get(name); !ok || v != value {
+			t.Errorf("%s: expected %v, got %v", name, value, v)
+		}
+	}
+	if _, ok := environment.Get("missing"); ok {
+		t.Errorf("missing is bound")
+	}
+
+	// Names with equal hashes share a collision list below the last level.
+	colliding := []Symbol{"costarring", "liquid"}
+	if hashOf(colliding[0]) != hashOf(colliding[1]) {
+		t.Fatalf("%v do not collide", colliding)
+	}
+	for i, name := range colliding {
+		environment.Define(name, NewNumber(big.NewRat(int64(i), 1)))
+	}
+	for i, name := range colliding {
+		if value, _ := environment.Get(name); !is_eq_number(int64(i))(value) {
+			t.Errorf("%s: expected %d, got %v", name, i, value)
+		}
+	}
+}
+
+func TestFork(t *testing.T) {
+	base := NewPersistentEnvironment()
+	AddDefaultBindings(base)
+	NewInterpreter(WithEnvironment(base), WithPrimitives()).Eval("{define rate 2}\n{define (cost (n)) {* n rate}}")
+
+	var wg sync.WaitGroup
+	results := make([]Term, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			interpreter := NewInterpreter(WithEnvironment(base.Fork()), WithPrimitives())
+			results[i] = interpreter.Eval(fmt.Sprintf("{define rate %d}\n{cost 10}", i))
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if !is_eq_number(int64(10 * i))(result) {
+			t.Errorf("fork %d: expected %d, got %v", i, 10*i, result)
+		}
+	}
+	if result := NewInterpreter(WithEnvironment(base), WithPrimitives()).Eval("{cost 10}"); !is_eq_number(20)(result) {
+		t.Errorf("base: expected 20, got %v", result)
+	}
+}
+
+func TestSet(t *testing.T) {
+	environments := map[string]Environment{
+		"local":      make(LocalEnvironment),
+		"sync":       NewSyncEnvironment(),
+		"persistent": NewPersistentEnvironment(),
+	}
+	for name, environment := range environments {
+		if environment.Set("x", String("set")) {
+			t.Errorf("%s: set an unbound variable", name)
+		}
+		if _, ok := environment.Get("x"); ok {
+			t.Errorf("%s: set bound an unbound variable", name)
+		}
+		environment.Define("x", String("defined"))
+		if !environment.Set("x", String("set")) {
+			t.Errorf("%s: failed to set a bound variable", name)
+		}
+		if value, _ := environment.Get("x"); value != String("set") {
+			t.Errorf("%s: expected set, got %v", name, value)
+		}
+
+		nested := &NestedEnvironment{Environment: make(LocalEnvironment), Parent: environment}
+		if !nested.Set("x", String("nested")) {
+			t.Errorf("%s: failed to set a variable bound in the parent", name)
+		}
+		if value, _ := environment.Get("x"); value != String("nested") {
+			t.Errorf("%s: expected the parent's binding to be set, got %v", name, value)
+		}
+		if nested.Set("y", String("nested")) {
+			t.Errorf("%s: set an unbound variable in a nested environment", name)
+		}
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	global := make(LocalEnvironment)
+	global.Define("x", String("global"))
+	global.Define("y", String("global"))
+	local := &NestedEnvironment{Environment: make(LocalEnvironment), Parent: global}
+	local.Define("x", String("local"))
+
+	if !same(Parent(local), global) || Parent(global) != nil {
+		t.Errorf("unexpected parents")
+	}
+	if bindings := Bindings(local); len(bindings) != 1 || bindings["x"] != String("local") {
+		t.Errorf("unexpected bindings %v", bindings)
+	}
+	if value, frame, ok := Lookup(local, "x"); !ok || value != String("local") || !same(frame, local) {
+		t.Errorf("x: got %v in %v", value, frame)
+	}
+	if value, frame, ok := Lookup(local, "y"); !ok || value != String("global") || !same(frame, global) {
+		t.Errorf("y: got %v in %v", value, frame)
+	}
+	if _, _, ok := Lookup(local, "z"); ok {
+		t.Errorf("z is bound")
+	}
+}
+
+// same reports whether a and b are the same environment, as environments
+// such as LocalEnvironment cannot be compared with ==.
+func same(a, b Environment) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
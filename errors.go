@@ -0,0 +1,171 @@
+package hu
+
+import (
+	"fmt"
+)
+
+// RaisedError is an error raised by raise, with a kind for catch clauses
+// to match and a value describing it.
+type RaisedError struct {
+	kind  Symbol
+	value Term
+}
+
+func (e RaisedError) String() string {
+	if e.value == nil {
+		return string(e.kind)
+	}
+	return fmt.Sprintf("%v: %v", e.kind, e.value)
+}
+
+// kindOf returns the kind of an error, as matched by catch clauses.
+func kindOf(err Term) Symbol {
+	switch e := err.(type) {
+	case RaisedError:
+		return e.kind
+	case UnboundVariableError:
+		return "unbound"
+	case CanceledError:
+		return "canceled"
+	case ExhaustedError:
+		return "exhausted"
+	}
+	return "error"
+}
+
+// describe returns a record describing err, which is what catch handlers
+// are applied to.
+func describe(err Term) Record {
+	record := Record{"kind": String(kindOf(err)), "message": String(fmt.Sprint(err))}
+	if e, ok := err.(RaisedError); ok {
+		record["value"] = e.value
+	}
+	return record
+}
+
+func raise(environment Environment, term Term) Term {
+	// not-found "no such user"
+	terms := term.(Tuple)
+	if len(terms) == 0 || len(terms) > 2 {
+		return Error("raise needs a kind and an optional value")
+	}
+	kind, ok := terms[0].(Symbol)
+	if !ok {
+		switch k := evaluate(environment, terms[0]).(type) {
+		case Symbol:
+			kind = k
+		case String:
+			kind = Symbol(k)
+		default:
+			return Error("unexpected type for kind")
+		}
+	}
+	var value Term
+	if len(terms) == 2 {
+		value = evaluate(environment, terms[1])
+	}
+	return RaisedError{kind, value}
+}
+
+// catch is a catch clause of try. With no kinds it catches any error
+// other than a cancellation or exhaustion, which are only caught by name.
+type catch struct {
+	kinds   []Symbol
+	handler Term
+}
+
+func (c catch) matches(err Term) bool {
+	kind := kindOf(err)
+	if len(c.kinds) == 0 {
+		return kind != "canceled" && kind != "exhausted"
+	}
+	for _, k := range c.kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// try evaluates its body expressions in order. If one aborts with an
+// error, the handler of the first catch clause matching it is applied to
+// a record describing the error, and its result is the result of try.
+// The expressions of a finally clause are evaluated last in any case:
+//
+//	{try body...
+//		(catch kind handler)
+//		(catch (kind...) handler)
+//		(catch handler)
+//		(finally expression...)}
+func try(environment Environment, term Term) Term {
+	var body Tuple
+	var catches []catch
+	var finally Tuple
+	for _, t := range term.(Tuple) {
+		clause, ok := t.(Tuple)
+		if !ok || len(clause) == 0 || (clause[0] != Symbol("catch") && clause[0] != Symbol("finally")) {
+			if len(catches) > 0 || finally != nil {
+				return Error("try body after its clauses")
+			}
+			body = append(body, t)
+			continue
+		}
+		if clause[0] == Symbol("finally") {
+			finally = clause[1:]
+			continue
+		}
+		var c catch
+		switch len(clause) {
+		case 2:
+		case 3:
+			switch kinds := clause[1].(type) {
+			case Symbol:
+				c.kinds = []Symbol{kinds}
+			case Tuple:
+				for _, kind := range kinds {
+					k, ok := kind.(Symbol)
+					if !ok {
+						return Error(fmt.Sprintf("unexpected kind %v", kind))
+					}
+					c.kinds = append(c.kinds, k)
+				}
+			default:
+				return Error(fmt.Sprintf("unexpected kind %v", kinds))
+			}
+		default:
+			return Error(fmt.Sprintf("malformed catch clause %v", clause))
+		}
+		c.handler = clause[len(clause)-1]
+		catches = append(catches, c)
+	}
+	if finally != nil {
+		defer func() {
+			evaluate(environment, begin(environment, finally))
+		}()
+	}
+	return catching(environment, body, catches)
+}
+
+func catching(environment Environment, body Tuple, catches []catch) (result Term) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if _, ok := r.(*escape); ok {
+			panic(r)
+		}
+		err, ok := r.(Term)
+		if !ok || !isError(err) {
+			err = Error(fmt.Sprintf("%v", r))
+		}
+		for _, c := range catches {
+			if c.matches(err) {
+				result = evaluate(environment, Application{c.handler, describe(err)})
+				return
+			}
+		}
+		panic(r)
+	}()
+	return evaluate(environment, begin(environment, body))
+}
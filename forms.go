@@ -0,0 +1,332 @@
+package hu
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The forms here leave the expression in tail position to the caller, by
+// returning it unevaluated, so recursion through them does not nest
+// evaluations.
+
+// sequence evaluates all but the last of body in environment and returns
+// the last, for the caller to evaluate in tail position.
+func sequence(environment Environment, body []Term) Term {
+	if len(body) == 0 {
+		return nil
+	}
+	for _, expression := range body[:len(body)-1] {
+		evaluate(environment, expression)
+	}
+	return body[len(body)-1]
+}
+
+// block returns a single term that evaluates body in sequence.
+func block(body []Term) Term {
+	if len(body) == 1 {
+		return body[0]
+	}
+	return Application(append([]Term{PrimitiveFunction(begin)}, body...))
+}
+
+// truth evaluates condition in environment, which must result in a
+// boolean, and returns it or an error.
+func truth(environment Environment, condition Term) (bool, Term) {
+	result, ok := evaluate(environment, condition).(Boolean)
+	if !ok {
+		return false, Error(fmt.Sprintf("%s is not a boolean", Format(condition)))
+	}
+	return bool(result), nil
+}
+
+// newFrame returns a new environment for bindings made as part of the
+// evaluation in environment.
+func newFrame(environment Environment) *NestedEnvironment {
+	return &NestedEnvironment{Environment: newBindings(environment), Parent: environment, caller: environment}
+}
+
+func cond(environment Environment, term Term) Term {
+	// (test body...)... (else body...)
+	for _, c := range term.(Tuple) {
+		clause, ok := c.(Tuple)
+		if !ok || len(clause) == 0 {
+			return Error(fmt.Sprintf("clause %s is not a test and a body", Format(c)))
+		}
+		if clause[0] != Symbol("else") {
+			holds, err := truth(environment, clause[0])
+			if err != nil {
+				return err
+			}
+			if !holds {
+				continue
+			}
+		}
+		return sequence(environment, clause[1:])
+	}
+	return nil
+}
+
+func caseForm(environment Environment, term Term) Term {
+	// key ((value...) body...)... (else body...)
+	terms := term.(Tuple)
+	if len(terms) == 0 {
+		return Error("case needs a key")
+	}
+	key := evaluate(environment, terms[0])
+	for _, c := range terms[1:] {
+		clause, ok := c.(Tuple)
+		if !ok || len(clause) == 0 {
+			return Error(fmt.Sprintf("clause %s is not values and a body", Format(c)))
+		}
+		if clause[0] == Symbol("else") {
+			return sequence(environment, clause[1:])
+		}
+		values, ok := clause[0].(Tuple)
+		if !ok {
+			values = Tuple{clause[0]}
+		}
+		for _, value := range values {
+			if equal(value, key) {
+				return sequence(environment, clause[1:])
+			}
+		}
+	}
+	return nil
+}
+
+func when(environment Environment, term Term) Term {
+	return conditionally(environment, "when", term.(Tuple), true)
+}
+
+func unless(environment Environment, term Term) Term {
+	return conditionally(environment, "unless", term.(Tuple), false)
+}
+
+// conditionally evaluates the body of a when or unless form if its test
+// results in expected.
+func conditionally(environment Environment, form string, terms Tuple, expected bool) Term {
+	if len(terms) == 0 {
+		return Error(form + " needs a test and a body")
+	}
+	holds, err := truth(environment, terms[0])
+	if err != nil {
+		return err
+	}
+	if holds != expected {
+		return nil
+	}
+	return sequence(environment, terms[1:])
+}
+
+func while(environment Environment, term Term) Term {
+	// test body...
+	terms := term.(Tuple)
+	if len(terms) == 0 {
+		return Error("while needs a test and a body")
+	}
+	for {
+		holds, err := truth(environment, terms[0])
+		if err != nil {
+			return err
+		}
+		if !holds {
+			return nil
+		}
+		for _, expression := range terms[1:] {
+			evaluate(environment, expression)
+		}
+	}
+}
+
+// forBinders appends the variable of a for loop to names.
+func forBinders(operands Tuple, names []Symbol) []Symbol {
+	if name, ok := operands[0].(Symbol); ok {
+		names = append(names, name)
+	}
+	return names
+}
+
+func forPrimitive(environment Environment, term Term) Term {
+	// x in tuple body...
+	// i from start to end [by step] body...
+	terms := term.(Tuple)
+	if len(terms) < 3 {
+		return Error("for needs a variable, in or from, and a body")
+	}
+	name, ok := terms[0].(Symbol)
+	if !ok {
+		return Error("unexpected type for variable")
+	}
+	iterate := func(value Term, body []Term) {
+		frame := newFrame(environment)
+		frame.Define(name, value)
+		for _, expression := range body {
+			evaluate(frame, expression)
+		}
+	}
+	switch terms[1] {
+	case Symbol("in"):
+		tuple, ok := datum(evaluate(environment, terms[2])).(Tuple)
+		if !ok {
+			return Error("for in a term that is not a tuple")
+		}
+		for _, element := range tuple {
+			iterate(quoted(element), terms[3:])
+		}
+	case Symbol("from"):
+		if len(terms) < 5 || terms[3] != Symbol("to") {
+			return Error("for from needs to")
+		}
+		body := terms[5:]
+		bounds := []Term{terms[2], terms[4]}
+		if len(terms) >= 7 && terms[5] == Symbol("by") {
+			bounds, body = append(bounds, terms[6]), terms[7:]
+		}
+		numbers := []*big.Rat{nil, nil, big.NewRat(1, 1)}
+		for i, bound := range bounds {
+			n, ok := evaluate(environment, bound).(*Number)
+			if !ok {
+				return Error(fmt.Sprintf("%s is not a number", Format(bound)))
+			}
+			numbers[i] = n.value
+		}
+		start, end, step := numbers[0], numbers[1], numbers[2]
+		if step.Sign() == 0 {
+			return Error("for by a step of zero")
+		}
+		for i := new(big.Rat).Set(start); i.Cmp(end) != step.Sign(); i = new(big.Rat).Add(i, step) {
+			iterate(makeNumber(environment, i), body)
+		}
+	default:
+		return Error("for needs in or from")
+	}
+	return nil
+}
+
+// letForm is a let form taken apart: the patterns bound, their values and
+// the body.
+type letForm struct {
+	patterns, values, body []Term
+}
+
+// parseLet takes apart the operands of a let form named form. Unless
+// shadowing is allowed, as in let*, no variable may be bound twice.
+func parseLet(form string, terms Tuple, shadowing bool) (letForm, Term) {
+	var let letForm
+	if len(terms) < 2 {
+		return let, Error(form + " needs bindings and a body")
+	}
+	bindings, ok := terms[0].(Tuple)
+	if !ok {
+		return let, Error(fmt.Sprintf("bindings %s of %s are not a tuple", Format(terms[0]), form))
+	}
+	bound := make(map[Symbol]bool)
+	for _, b := range bindings {
+		binding, ok := b.(Tuple)
+		if !ok || len(binding) != 2 {
+			return let, Error(fmt.Sprintf("binding %s of %s is not a pattern and a value", Format(b), form))
+		}
+		switch binding[0].(type) {
+		case Symbol, Tuple, Application:
+		default:
+			return let, Error(fmt.Sprintf("%s bound by %s is not a name or a pattern", Format(binding[0]), form))
+		}
+		for _, name := range patternVariables(binding[0], nil) {
+			if bound[name] && !shadowing {
+				return let, Error(fmt.Sprintf("%s is bound twice by %s", name, form))
+			}
+			bound[name] = true
+		}
+		let.patterns = append(let.patterns, binding[0])
+		let.values = append(let.values, binding[1])
+	}
+	let.body = terms[1:]
+	return let, nil
+}
+
+// letBinders appends the variables bound by let, let* or letrec, and the
+// name of a named let, to names.
+func letBinders(operands Tuple, names []Symbol) []Symbol {
+	bindings, ok := operands[0].(Tuple)
+	if name, named := operands[0].(Symbol); named && len(operands) > 1 {
+		names = append(names, name)
+		bindings, ok = operands[1].(Tuple)
+	}
+	if ok {
+		for _, b := range bindings {
+			if b, ok := b.(Tuple); ok && len(b) > 0 {
+				names = patternVariables(b[0], names)
+			}
+		}
+	}
+	return names
+}
+
+func let(environment Environment, term Term) Term {
+	// ((pattern value)...) body...
+	// name ((pattern value)...) body...
+	terms := term.(Tuple)
+	if len(terms) > 0 {
+		if name, ok := terms[0].(Symbol); ok {
+			return namedLet(environment, name, terms[1:])
+		}
+	}
+	let, err := parseLet("let", terms, false)
+	if err != nil {
+		return err
+	}
+	frame := newFrame(environment)
+	for i := range let.patterns {
+		if err := extendOperand(frame, environment, let.patterns[i], let.values[i]); err != nil {
+			return err
+		}
+	}
+	return Closure{sequence(frame, let.body), frame}
+}
+
+func letStar(environment Environment, term Term) Term {
+	// ((pattern value)...) body...
+	let, err := parseLet("let*", term.(Tuple), true)
+	if err != nil {
+		return err
+	}
+	frame := newFrame(environment)
+	for i := range let.patterns {
+		if i > 0 {
+			frame = &NestedEnvironment{Environment: newBindings(frame), Parent: frame, caller: environment}
+		}
+		if err := extendOperand(frame, frame.Parent, let.patterns[i], let.values[i]); err != nil {
+			return err
+		}
+	}
+	return Closure{sequence(frame, let.body), frame}
+}
+
+func letrec(environment Environment, term Term) Term {
+	// ((pattern value)...) body...
+	let, err := parseLet("letrec", term.(Tuple), false)
+	if err != nil {
+		return err
+	}
+	frame := newFrame(environment)
+	for i := range let.patterns {
+		if err := extendOperand(frame, frame, let.patterns[i], let.values[i]); err != nil {
+			return err
+		}
+	}
+	return Closure{sequence(frame, let.body), frame}
+}
+
+// namedLet binds name to a function of the patterns bound by the let,
+// with its body, and applies it to their values.
+func namedLet(environment Environment, name Symbol, terms Tuple) Term {
+	// loop ((pattern value)...) body...
+	let, err := parseLet("let", terms, false)
+	if err != nil {
+		return err
+	}
+	frame := newFrame(environment)
+	loop := Abstraction{Tuple{nil, Tuple(let.patterns)}, block(let.body), frame}
+	frame.Define(name, loop)
+	return Application(append([]Term{loop}, let.values...))
+}
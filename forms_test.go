@@ -0,0 +1,98 @@
+package hu
+
+import (
+	"testing"
+)
+
+func TestForms(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define (sign (x)) {cond ({< x 0} "negative") ({< x 1} "zero") (else "positive")}}
+{define (kind (x)) {case x ((1 2 3) "small") ('big "big") (else "other")}}`)
+
+	tests := []testCase{
+		{"{sign 5}", is_eq(String("positive"))},
+		{"{sign 0}", is_eq(String("zero"))},
+		{"{sign {- 0 5}}", is_eq(String("negative"))},
+		{"{cond (false 1)}", is_eq(nil)},
+		{"{cond (1 2)}", is_error()},
+		{"{kind 2}", is_eq(String("small"))},
+		{"{kind 'big}", is_eq(String("big"))},
+		{"{kind 7}", is_eq(String("other"))},
+		{"{when true 1 2}", is_eq_number(2)},
+		{"{when false 1 2}", is_eq(nil)},
+		{"{unless false 3}", is_eq_number(3)},
+		{"{when}", is_error()},
+		{"{begin {define n 0} {define total 0} {while {< n 5} {set n {+ n 1}} {set total {+ total n}}} total}", is_eq_number(15)},
+		{"{begin {define total 0} {for i from 1 to 4 {set total {+ total i}}} total}", is_eq_number(10)},
+		{"{begin {define total 0} {for i from 10 to 1 by {- 0 3} {set total {+ total i}}} total}", is_eq_number(22)},
+		{"{begin {define total 0} {for x in (1 2 3) {set total {+ total x}}} total}", is_eq_number(6)},
+		{"{for i from 1 to 2 by 0 i}", is_error()},
+		{"{for i through 2 i}", is_error()},
+		{"{let* ((x 1) (y {+ x 1})) {+ x y}}", is_eq_number(3)},
+		{"{letrec ((even {lambda (n) {if {< n 1} true {odd {- n 1}}}}) (odd {lambda (n) {if {< n 1} false {even {- n 1}}}})) {even 10}}", is_eq(Boolean(true))},
+		{"{let loop ((i 0) (total 0)) {if {< i 5} {loop {+ i 1} {+ total i}} total}}", is_eq_number(10)},
+		{"{let* (x) x}", is_error()},
+		{"{letrec ((1 2)) 3}", is_error()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	interpreter := NewInterpreter(WithStrategy(CallByValue), WithLimits(Limits{Depth: 50}))
+	interpreter.Eval(`{define (count (n)) {cond ({< n 1} "done") (else {when true {count {- n 1}}})}}`)
+
+	tests := []testCase{
+		{"{count 1000}", is_eq(String("done"))},
+		{"{let loop ((i 0)) {if {< i 1000} {loop {+ i 1}} i}}", is_eq_number(1000)},
+		{"{begin {define (deep (n)) {if {< n 1} 0 {+ 1 {deep {- n 1}}}}} {deep 1000}}", is_exhausted()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestLet(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define x 10}
+{define pair (7 4)}
+{define names '(a b)}`)
+
+	tests := []testCase{
+		{"{let ((x 2)) {+ x x}}", is_eq_number(4)},
+		{"{let ((x 1) (y x)) y}", is_eq_number(10)},
+		{"{let ((y 1)) {define z {+ y 1}} {* z 3}}", is_eq_number(6)},
+		{"{let () 5}", is_eq_number(5)},
+		{"{begin {let ((x 1)) x} x}", is_eq_number(10)},
+		{"{let (((a b) pair)) {- a b}}", is_eq_number(3)},
+		{"{let (((a b) (1 2)) (c 3)) {+ a {+ b c}}}", is_eq_number(6)},
+		{"{let (((first rest ...) (1 2 3))) rest}", func(result Term) bool { return Format(result) == "(2 3)" }},
+		{`{let (({record (name n)} {record (name "hu")})) n}`, is_eq(String("hu"))},
+		{"{let (((p q) names)) q}", func(result Term) bool { return Format(result) == "'b" }},
+		{"{let (((x y) '(a b))) x}", func(result Term) bool { return Format(result) == "'a" }},
+		{"{let* ((a (1 2)) ((b c) a) (a {+ b c})) a}", is_eq_number(3)},
+		{"{letrec ((fact {lambda (n) {if {< n 1} 1 {* n {fact {- n 1}}}}})) {fact 5}}", is_eq_number(120)},
+		{"{let (((a b) (1 2 3))) a}", is_error()},
+		{"{let}", is_error()},
+		{"{let ((x 1))}", is_error()},
+		{"{let x 1}", is_error()},
+		{"{let (x) x}", is_error()},
+		{"{let ((x 1 2)) x}", is_error()},
+		{"{let ((1 2)) 3}", is_error()},
+		{"{let ((x 1) (x 2)) x}", is_error()},
+		{"{letrec (((a a) (1 2))) a}", is_error()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
@@ -5,6 +5,8 @@ package hu
 import (
 	"fmt"
 	"math/big"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -38,10 +40,34 @@ type Number struct {
 	value *big.Rat
 }
 
+// NewNumber returns a Number with the value of x.
+func NewNumber(x *big.Rat) *Number {
+	return &Number{new(big.Rat).Set(x)}
+}
+
 func (n *Number) String() string {
 	return n.value.RatString()
 }
 
+// Rat returns a copy of the value of n.
+func (n *Number) Rat() *big.Rat {
+	return new(big.Rat).Set(n.value)
+}
+
+// Int64 returns the value of n and whether it is an integer that can be
+// represented exactly as an int64.
+func (n *Number) Int64() (int64, bool) {
+	if !n.value.IsInt() || !n.value.Num().IsInt64() {
+		return 0, false
+	}
+	return n.value.Num().Int64(), true
+}
+
+// Float64 returns the nearest float64 value to n and whether it is exact.
+func (n *Number) Float64() (float64, bool) {
+	return n.value.Float64()
+}
+
 type Symbol string
 
 func (s Symbol) String() string {
@@ -50,6 +76,9 @@ func (s Symbol) String() string {
 
 func (s Symbol) Reduce(environment Environment) Term {
 	v, _ := environment.Get(s)
+	if property, ok := v.(*Property); ok {
+		return property.get(environment)
+	}
 	return v
 }
 
@@ -62,13 +91,34 @@ func (s String) String() string {
 type Tuple []Term
 
 func (tuple Tuple) String() string {
-	return fmt.Sprintf("(%v)", []Term(tuple))
+	return Format(tuple)
 }
 
 type Set []Term
 
 func (set Set) String() string {
-	return fmt.Sprintf("{%v}", []Term(set))
+	return Format(set)
+}
+
+type Record map[Symbol]Term
+
+func (record Record) String() string {
+	var fields []string
+	for _, name := range sortedNames(record) {
+		fields = append(fields, fmt.Sprintf("(%s %s)", name, Format(record[name])))
+	}
+	return fmt.Sprintf("{record %s}", strings.Join(fields, " "))
+}
+
+// sortedNames returns the names bound in a record or environment in
+// order.
+func sortedNames(bindings map[Symbol]Term) []Symbol {
+	var names []Symbol
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
 }
 
 type Part []Term
@@ -109,16 +159,18 @@ func (p Primitive) Reduce(environment Environment) Term {
 type Application []Term
 
 func (application Application) String() string {
-	return fmt.Sprintf("{%v}", []Term(application))
+	return Format(application)
 }
 
 func (application Application) Reduce(environment Environment) Term {
 	for i, term := range application {
-		switch operator := Evaluate(environment, term).(type) {
+		switch operator := evaluate(environment, term).(type) {
+		case Macro:
+			return operator.expand(environment, application)
 		case Operator:
 			var operands Term
 			switch operator.(type) {
-			case PrimitiveFunction:
+			case PrimitiveFunction, GoFunction, *Continuation:
 				operands = Tuple(application[i+1:])
 			default:
 				lhs := Tuple(application[0:i])
@@ -131,14 +183,24 @@ func (application Application) Reduce(environment Environment) Term {
 	return nil
 }
 
+// Abstraction is a function or operator closed over Environment, the
+// environment it was made in. Its body is evaluated in a new environment
+// extending Environment or, if that is nil, the caller's environment.
 type Abstraction struct {
-	Parameters Term
-	Term       Term
+	Parameters  Term
+	Term        Term
+	Environment Environment
 }
 
 func (a Abstraction) apply(e Environment, values Term) Term {
-	c := &NestedEnvironment{Environment: make(LocalEnvironment), Parent: e}
-	Extend(c, a.Parameters, values)
+	parent := rebase(a.Environment, e)
+	if parent == nil {
+		parent = e
+	}
+	c := &NestedEnvironment{Environment: newBindings(parent), Parent: parent, caller: e}
+	if err := extend(c, e, a.Parameters, values); err != nil {
+		return err
+	}
 	return Closure{a.Term, c}
 }
 
@@ -155,8 +217,44 @@ func (closure Closure) String() string {
 	return fmt.Sprintf("#<Closure> %v %v\n", closure.Term, closure.Environment)
 }
 
+// Reduce evaluates the closure's term in its environment, as part of the
+// evaluation in environment that forces it.
 func (closure Closure) Reduce(environment Environment) Term {
-	return Evaluate(closure.Environment, closure.Term)
+	return evaluate(closure.environment(environment), closure.Term)
+}
+
+// environment returns the environment in which the closure's term is
+// evaluated when caller forces it.
+func (closure Closure) environment(caller Environment) Environment {
+	if nested, ok := closure.Environment.(*NestedEnvironment); ok && identical(nested.caller, caller) {
+		return closure.Environment
+	}
+	return &forcedEnvironment{closure.Environment, caller}
+}
+
+// identical reports whether a and b are the same environment. Maps are
+// not comparable, so LocalEnvironments are never identical.
+func identical(a, b Environment) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return reflect.TypeOf(a).Comparable() && reflect.TypeOf(b).Comparable() && a == b
+}
+
+// forcedEnvironment is the environment of a closure being forced by an
+// evaluation in caller; all bindings are delegated to the closure's
+// environment.
+type forcedEnvironment struct {
+	Environment
+	caller Environment
+}
+
+func (environment *forcedEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *forcedEnvironment) wrapped() Environment {
+	return environment.Environment
 }
 
 type Error string
@@ -174,40 +272,136 @@ func (e UnboundVariableError) String() string {
 	return "Unbound Variable: " + e.variable.String() + " operation: " + e.operation
 }
 
-func Extend(environment Environment, variables, values Term) {
-	switch vars := variables.(type) {
-	case Tuple:
-		vals := values.(Tuple)
-		if len(vals) != len(vars) {
-			fmt.Println("type mismatch:", vals, vars)
+// Extend binds variables, a pattern as matched by match, in environment
+// to values, which are evaluated in its parent. It returns an error if
+// values do not match, and nil otherwise.
+func Extend(environment Environment, variables, values Term) Term {
+	return extend(environment, environment.(*NestedEnvironment).Parent, variables, values)
+}
+
+// extend binds variables in environment to values, which are evaluated
+// in caller, or returns an error if values do not match variables. An
+// operand matched against a pattern that is not a binder is evaluated
+// first. The parameter list of a lambda, (nil (parameter...)), is
+// matched as described by parameters.
+func extend(environment, caller Environment, variables, values Term) Term {
+	m := operandMatcher(environment, caller)
+	var bindings []bound
+	var defaults map[Symbol]Term
+	var defaulted []Symbol
+	if list, operands, ok := parameterList(variables, values); ok {
+		p, err := parseParameters(list)
+		if err != nil {
+			return err
 		}
-		for i, v := range vars {
-			val := vals[i]
-			Extend(environment, v, val)
+		if defaulted, err = p.match(m, operands, &bindings); err != nil {
+			return err
 		}
-	case Symbol:
-		if vars != Term(nil) {
-			parent := environment.(*NestedEnvironment).Parent
-			environment.Define(vars, Closure{values, parent})
+		defaults = p.defaults
+	} else if !m.match(variables, values, &bindings) {
+		return m.mismatch()
+	}
+	bindMatched(environment, caller, bindings)
+	strategy := strategyOf(environment)
+	for _, name := range defaulted {
+		bind(environment, 1)
+		if strategy == CallByValue {
+			environment.Define(name, evaluate(environment, defaults[name]))
+		} else {
+			environment.Define(name, Closure{defaults[name], environment})
 		}
 	}
+	return nil
+}
+
+// extendOperand binds the variables of pattern in environment to operand,
+// which is evaluated in caller, as match binds them to its subject, or
+// returns an error if operand does not match pattern.
+func extendOperand(environment, caller Environment, pattern, operand Term) Term {
+	m := operandMatcher(environment, caller)
+	var bindings []bound
+	if !m.operand(pattern, operand, &bindings) {
+		return m.mismatch()
+	}
+	bindMatched(environment, caller, bindings)
+	return nil
 }
 
+// operandMatcher returns a matcher that evaluates operands in caller and
+// charges environment for what it allocates.
+func operandMatcher(environment, caller Environment) *matcher {
+	return &matcher{force: func(term Term) Term {
+		if _, ok := term.(Tuple); ok {
+			return term
+		}
+		return evaluate(caller, term)
+	}, allocate: func(n int) { allocate(environment, n) }}
+}
+
+// bindMatched defines the bindings of a match in environment, in order,
+// evaluating their terms in caller first under CallByValue.
+func bindMatched(environment, caller Environment, bindings []bound) {
+	strategy := strategyOf(environment)
+	for _, b := range bindings {
+		bind(environment, 1)
+		if strategy == CallByValue {
+			environment.Define(b.name, evaluate(caller, b.term))
+		} else {
+			environment.Define(b.name, Closure{b.term, caller})
+		}
+	}
+}
+
+// parameterList returns the parameter list of a lambda and the operands
+// it is applied to, if variables and values are those.
+func parameterList(variables, values Term) (Tuple, Tuple, bool) {
+	v, ok := variables.(Tuple)
+	if !ok || len(v) != 2 || v[0] != nil {
+		return nil, nil, false
+	}
+	list, ok := v[1].(Tuple)
+	if !ok {
+		return nil, nil, false
+	}
+	operands, ok := values.(Tuple)
+	if !ok || len(operands) != 2 {
+		return nil, nil, false
+	}
+	rhs, ok := operands[1].(Tuple)
+	return list, rhs, ok
+}
+
+// Environment binds variables to values. Define binds a variable in the
+// environment itself, replacing any binding it already has there. Set
+// rebinds a variable that is already bound, in the nearest environment
+// binding it, and reports false, binding nothing, if it is unbound. Get
+// returns the value of a variable, or an UnboundVariableError and false.
 type Environment interface {
 	Define(variable Symbol, value Term)
 	Set(variable Symbol, value Term) bool
 	Get(variable Symbol) (Term, bool)
 }
 
+// LocalEnvironment is an Environment backed by a map. It is not safe for
+// concurrent use: while one goroutine defines or sets a variable, no other
+// may use the environment. Use a SyncEnvironment for bindings that are
+// shared between goroutines.
 type LocalEnvironment map[Symbol]Term
 
+func (environment LocalEnvironment) String() string {
+	return "#<environment>"
+}
+
 func (environment LocalEnvironment) Define(variable Symbol, value Term) {
 	environment[variable] = value
 }
 
 func (environment LocalEnvironment) Set(variable Symbol, value Term) bool {
-	environment[variable] = value
-	return true
+	_, ok := environment[variable]
+	if ok {
+		environment[variable] = value
+	}
+	return ok
 }
 
 func (environment LocalEnvironment) Get(variable Symbol) (Term, bool) {
@@ -220,9 +414,17 @@ func (environment LocalEnvironment) Get(variable Symbol) (Term, bool) {
 
 }
 
+// NestedEnvironment is an Environment that extends Parent with the
+// bindings in Environment. It is safe for concurrent use exactly when both
+// of those are.
 type NestedEnvironment struct {
 	Environment Environment
 	Parent      Environment
+
+	// caller is the environment of the application that made this one,
+	// if any; evaluations are controlled from the caller rather than
+	// from wherever Parent was defined.
+	caller Environment
 }
 
 func (environment *NestedEnvironment) String() string {
@@ -256,22 +458,107 @@ func (ne *NestedEnvironment) Get(variable Symbol) (Term, bool) {
 	}
 }
 
-type Property struct {
-	Name   Symbol
-	DidSet Abstraction
+// Parent returns the environment that environment extends, or nil if it
+// has none.
+func Parent(environment Environment) Environment {
+	switch e := environment.(type) {
+	case *NestedEnvironment:
+		return e.Parent
+	case wrappedEnvironment:
+		return Parent(e.wrapped())
+	}
+	return nil
 }
 
-func (property Property) String() string {
-	return fmt.Sprintf("#<property> %v", property.Name)
+// Bindings returns a copy of the bindings made in environment itself,
+// not including those of its parents.
+func Bindings(environment Environment) map[Symbol]Term {
+	switch e := environment.(type) {
+	case LocalEnvironment:
+		bindings := make(map[Symbol]Term, len(e))
+		for name, value := range e {
+			bindings[name] = value
+		}
+		return bindings
+	case *LocalEnvironment:
+		return Bindings(*e)
+	case *NestedEnvironment:
+		return Bindings(e.Environment)
+	case *SyncEnvironment:
+		return e.snapshot()
+	case *PersistentEnvironment:
+		return e.snapshot()
+	case wrappedEnvironment:
+		return Bindings(e.wrapped())
+	}
+	return nil
+}
+
+// Lookup is like Get, but also returns the environment, environment or
+// one of its parents, in which variable is bound.
+func Lookup(environment Environment, variable Symbol) (Term, Environment, bool) {
+	for frame := environment; frame != nil; frame = Parent(frame) {
+		for wrapper, ok := frame.(wrappedEnvironment); ok; wrapper, ok = frame.(wrappedEnvironment) {
+			frame = wrapper.wrapped()
+		}
+		own := frame
+		if nested, ok := frame.(*NestedEnvironment); ok {
+			own = nested.Environment
+		}
+		if value, ok := own.Get(variable); ok {
+			return value, frame, true
+		}
+	}
+	return UnboundVariableError{variable, "lookup"}, nil, false
 }
 
-func Evaluate(environment Environment, term Term) Term {
+// Evaluate reduces term in environment until it is no longer reducible.
+// If a reduction results in an error, the evaluation stops and Evaluate
+// returns the error.
+func Evaluate(environment Environment, term Term) (result Term) {
+	defer func() {
+		if x := recover(); x != nil {
+			if t, ok := x.(Term); ok && isError(t) {
+				result = t
+				return
+			}
+			panic(x)
+		}
+	}()
+	return evaluate(environment, term)
+}
+
+// evaluate is Evaluate, except that an error aborts the evaluation by
+// panicking with it, which Evaluate, GuardedEvaluate and try recover.
+func evaluate(environment Environment, term Term) Term {
+	control := controlOf(environment)
+	if control != nil {
+		control.enter()
+		defer control.leave()
+	}
+	reduced := false
 tailcall:
 	switch t := term.(type) {
+	case Closure:
+		// Evaluation continues in the closure's environment rather than
+		// nesting, so calls in tail position do not grow the stack.
+		if control != nil {
+			control.step()
+		}
+		environment, term = t.environment(environment), t.Term
+		reduced = true
+		goto tailcall
 	case Reducible:
+		if control != nil {
+			control.step()
+		}
 		term = t.Reduce(environment)
+		reduced = true
 		goto tailcall
 	}
+	if reduced && isError(term) {
+		panic(term)
+	}
 	return term
 }
 
@@ -284,6 +571,6 @@ func GuardedEvaluate(environment Environment, expression Term) (result Term) {
 			result = Error(fmt.Sprintf("%v", x))
 		}
 	}()
-	result = Evaluate(environment, expression)
+	result = evaluate(environment, expression)
 	return
 }
@@ -2,18 +2,25 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/eikeon/hu"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 )
 
 func main() {
 	filenameFlag := flag.String("filename", "-", "filename from which to read and execute program")
+	stepsFlag := flag.Int("steps", 0, "maximum number of reductions per expression (0 for no limit)")
+	depthFlag := flag.Int("depth", 0, "maximum evaluation depth per expression (0 for no limit)")
+	restoreFlag := flag.String("restore", "", "filename from which to restore a saved session")
 	flag.Parse()
 	filename := *filenameFlag
+	limits := hu.Limits{Steps: *stepsFlag, Depth: *depthFlag}
 
 	var reader io.RuneScanner
 	if filename == "-" {
@@ -26,13 +33,26 @@ func main() {
 		reader = bufio.NewReader(f)
 	}
 
-	environment := &hu.LocalEnvironment{}
-	hu.AddDefaultBindings(environment)
+	interpreter, err := newInterpreter(limits, *restoreFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	expressions := make(chan hu.Term)
+	go func() {
+		for {
+			expression := hu.Read(reader)
+			expressions <- expression
+			if expression == nil {
+				return
+			}
+		}
+	}()
 
 	var result hu.Term
 	fmt.Printf("hu> ")
 	for {
-		expression := hu.Read(reader)
+		expression := next(interpreter, expressions)
 		if expression != nil {
 			if expression == hu.Symbol("\n") {
 				if result != nil {
@@ -41,7 +61,7 @@ func main() {
 				fmt.Printf("hu> ")
 				continue
 			} else {
-				result = hu.GuardedEvaluate(environment, expression)
+				result = evaluate(interpreter, expression)
 			}
 		} else {
 			fmt.Fprintf(os.Stdout, "Goodbye!\n")
@@ -49,3 +69,95 @@ func main() {
 		}
 	}
 }
+
+// newInterpreter returns an interpreter with a save primitive saving its
+// session, restoring the session saved in the file named restore if it is
+// not empty.
+func newInterpreter(limits hu.Limits, restore string) (*hu.Interpreter, error) {
+	var interpreter *hu.Interpreter
+	registry := hu.DefaultRegistry()
+	save := hu.PrimitiveFunction(func(environment hu.Environment, term hu.Term) hu.Term {
+		filename, ok := hu.Evaluate(environment, term.(hu.Tuple)[0]).(hu.String)
+		if !ok {
+			return hu.Error("save requires a filename")
+		}
+		if err := saveSession(string(filename), interpreter.Environment(), registry); err != nil {
+			return hu.Error(err.Error())
+		}
+		return nil
+	})
+	registry.Register("save", save)
+
+	options := []hu.Option{hu.WithLimits(limits)}
+	if restore != "" {
+		environment, err := restoreSession(restore, registry)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, hu.WithEnvironment(environment), hu.WithPrimitives())
+	}
+	interpreter = hu.NewInterpreter(options...)
+	hu.AddPrimitive(interpreter.Environment(), "save", save)
+	return interpreter, nil
+}
+
+// saveSession writes environment to the file named filename, replacing
+// any earlier session only once the new one is complete.
+func saveSession(filename string, environment hu.Environment, registry *hu.Registry) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := hu.NewEncoder(f, registry).EncodeEnvironment(environment); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func restoreSession(filename string, registry *hu.Registry) (hu.Environment, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return hu.NewDecoder(f, registry).DecodeEnvironment()
+}
+
+// next returns the next expression read, running the jobs scheduled by
+// the program while waiting for it.
+func next(interpreter *hu.Interpreter, expressions <-chan hu.Term) hu.Term {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		interpreter.Run(ctx)
+	}()
+	expression := <-expressions
+	cancel()
+	<-done
+	return expression
+}
+
+// evaluate evaluates expression, abandoning it if an interrupt arrives.
+// Interrupts are caught only while evaluating, so that one at the prompt
+// ends the session as usual.
+func evaluate(interpreter *hu.Interpreter, expression hu.Term) hu.Term {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return interpreter.Evaluate(ctx, expression)
+}
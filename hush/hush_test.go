@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eikeon/hu"
+)
+
+func TestSession(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session")
+	interpreter, err := newInterpreter(hu.Limits{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	interpreter.Eval(`{define x 2}
+{define (double (n)) {* n 2}}
+{variable width}
+{variable height}
+{computed area {* width height}}
+{set width 3}
+{set height 4}`)
+	if result := interpreter.Eval(`{save "` + filename + `"}`); result != nil {
+		t.Fatalf("save resulted in %v", result)
+	}
+
+	restored, err := newInterpreter(hu.Limits{}, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{double x}", "4"},
+		{"area", "12"},
+		{"{begin {set width 5} area}", "20"},
+		{"{+ 1 2}", "3"},
+	}
+	for _, test := range tests {
+		if result := restored.Eval(test.input); hu.Format(result) != test.expected {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+	if result := restored.Eval(`{save "` + filename + `"}`); result != nil {
+		t.Errorf("save of the restored session resulted in %v", result)
+	}
+	restored.Eval("{define jobs 0}\n{set jobs {channel 1}}")
+	if result := restored.Eval(`{save "` + filename + `"}`); !strings.Contains(hu.Format(result), "jobs") {
+		t.Errorf("save with a channel bound resulted in %v", result)
+	}
+}
+
+func TestNext(t *testing.T) {
+	interpreter, err := newInterpreter(hu.Limits{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	interpreter.Eval(`{define fired false}
+{after "1ms" {lambda () {set fired true}}}`)
+	expressions := make(chan hu.Term)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		expressions <- hu.Symbol("fired")
+	}()
+	expression := next(interpreter, expressions)
+	if result := interpreter.Evaluate(context.Background(), expression); result != hu.Boolean(true) {
+		t.Errorf("expected the job to run while waiting for input, got %v", result)
+	}
+}
+
+func TestEvaluateInterrupt(t *testing.T) {
+	interpreter, err := newInterpreter(hu.Limits{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// guard keeps the interrupts sent here from ending the test.
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, os.Interrupt)
+	defer signal.Stop(guard)
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	process.Signal(os.Interrupt)
+	<-guard
+	if result := evaluate(interpreter, hu.Read(strings.NewReader("{+ 1 2}"))); hu.Format(result) != "3" {
+		t.Errorf("an interrupt at the prompt canceled the next expression: %v", result)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		process.Signal(os.Interrupt)
+	}()
+	result := evaluate(interpreter, hu.Read(strings.NewReader("{begin {define (loop (n)) {loop n}} {loop 1}}")))
+	if _, ok := result.(hu.CanceledError); !ok {
+		t.Errorf("expected an interrupt to cancel the evaluation, got %v", result)
+	}
+}
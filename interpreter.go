@@ -0,0 +1,239 @@
+package hu
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Strategy determines when the operands of an abstraction are evaluated.
+type Strategy int
+
+const (
+	CallByName  Strategy = iota // operands are evaluated each time they are used
+	CallByValue                 // operands are evaluated once, before the body
+)
+
+// An Interpreter evaluates hu programs in an environment of its own. It
+// writes only to the writers and logger it is given.
+type Interpreter struct {
+	environment Environment
+	primitives  []PrimitiveSet
+	stdout      io.Writer
+	stderr      io.Writer
+	logger      *log.Logger
+	strategy    Strategy
+	limits      Limits
+	clock       Clock
+	scheduler   scheduler
+}
+
+// An Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithEnvironment makes the interpreter evaluate in environment rather
+// than in a new SyncEnvironment. An interpreter may evaluate in several
+// goroutines at once, and spawn tasks, only if its environment is safe
+// for concurrent use, as a SyncEnvironment is.
+func WithEnvironment(environment Environment) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.environment = environment
+	}
+}
+
+// WithPrimitives makes the interpreter bind only the given primitive sets
+// rather than the default bindings. With no sets, nothing is bound, as
+// when restoring an environment that already has its bindings.
+func WithPrimitives(sets ...PrimitiveSet) Option {
+	return func(interpreter *Interpreter) {
+		if interpreter.primitives == nil {
+			interpreter.primitives = []PrimitiveSet{}
+		}
+		interpreter.primitives = append(interpreter.primitives, sets...)
+	}
+}
+
+// WithStdout sets the writer used by print; the default is os.Stdout.
+func WithStdout(w io.Writer) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.stdout = w
+	}
+}
+
+// WithStderr sets the writer used by warn; the default is os.Stderr.
+func WithStderr(w io.Writer) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.stderr = w
+	}
+}
+
+// WithLogger sets the logger for diagnostics, such as the failures of
+// scheduled jobs; by default they are discarded.
+func WithLogger(logger *log.Logger) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.logger = logger
+	}
+}
+
+// WithStrategy sets the evaluation strategy; the default is CallByName.
+func WithStrategy(strategy Strategy) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.strategy = strategy
+	}
+}
+
+// WithLimits bounds each evaluation done by the interpreter.
+func WithLimits(limits Limits) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.limits = limits
+	}
+}
+
+// WithClock sets the clock that scheduled jobs are timed by; the default
+// is the system clock.
+func WithClock(clock Clock) Option {
+	return func(interpreter *Interpreter) {
+		interpreter.clock = clock
+	}
+}
+
+func NewInterpreter(options ...Option) *Interpreter {
+	interpreter := &Interpreter{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		logger: discard,
+		clock:  systemClock{},
+	}
+	for _, option := range options {
+		option(interpreter)
+	}
+	if interpreter.environment == nil {
+		interpreter.environment = NewSyncEnvironment()
+	}
+	if interpreter.primitives == nil {
+		AddDefaultBindings(interpreter.environment)
+	} else {
+		AddPrimitives(interpreter.environment, interpreter.primitives...)
+	}
+	return interpreter
+}
+
+func (interpreter *Interpreter) Environment() Environment {
+	return interpreter.environment
+}
+
+// Evaluate expands the macros in term and evaluates it, giving up once
+// ctx is done or the interpreter's limits are exceeded, whether while
+// expanding or evaluating.
+func (interpreter *Interpreter) Evaluate(ctx context.Context, term Term) Term {
+	c := newControl(ctx, interpreter.limits, interpreter)
+	return GuardedEvaluate(&controlledEnvironment{interpreter.environment, c}, Primitive(func(environment Environment) Term {
+		return Expand(environment, term)
+	}))
+}
+
+// EvalContext reads and evaluates the expressions in, stopping at the
+// first error. It returns the result of the last expression evaluated.
+func (interpreter *Interpreter) EvalContext(ctx context.Context, in io.RuneScanner) (result Term) {
+	for {
+		expression := Read(in)
+		switch e := expression.(type) {
+		case nil:
+			return
+		case Error:
+			return e
+		case Symbol:
+			if strings.TrimSpace(string(e)) == "" {
+				continue
+			}
+		}
+		result = interpreter.Evaluate(ctx, expression)
+		if isError(result) {
+			return
+		}
+	}
+}
+
+func (interpreter *Interpreter) EvalReader(in io.RuneScanner) Term {
+	return interpreter.EvalContext(context.Background(), in)
+}
+
+func (interpreter *Interpreter) Eval(source string) Term {
+	return interpreter.EvalReader(strings.NewReader(source))
+}
+
+// Load evaluates the program in the file named path.
+func (interpreter *Interpreter) Load(path string) (Term, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return interpreter.EvalReader(bufio.NewReader(f)), nil
+}
+
+func (interpreter *Interpreter) Define(name string, value Term) {
+	interpreter.environment.Define(Symbol(name), value)
+}
+
+// Subscribe calls function with the new and old values of the property
+// bound to name whenever it changes, until the returned function is
+// called.
+func (interpreter *Interpreter) Subscribe(name string, function func(value, old Term)) (unsubscribe func(), err error) {
+	value, _ := interpreter.environment.Get(Symbol(name))
+	property, ok := value.(*Property)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a property", name)
+	}
+	return property.Subscribe(function), nil
+}
+
+// Call applies the operator bound to name to arguments.
+func (interpreter *Interpreter) Call(name string, arguments ...Term) Term {
+	application := append(Application{Symbol(name)}, arguments...)
+	return interpreter.Evaluate(context.Background(), application)
+}
+
+// interpreterOf returns the interpreter evaluating in environment, or nil
+// if the evaluation was not started by an Interpreter.
+func interpreterOf(environment Environment) *Interpreter {
+	if c := controlOf(environment); c != nil {
+		return c.interpreter
+	}
+	return nil
+}
+
+func stdoutOf(environment Environment) io.Writer {
+	if interpreter := interpreterOf(environment); interpreter != nil {
+		return interpreter.stdout
+	}
+	return os.Stdout
+}
+
+func stderrOf(environment Environment) io.Writer {
+	if interpreter := interpreterOf(environment); interpreter != nil {
+		return interpreter.stderr
+	}
+	return os.Stderr
+}
+
+func strategyOf(environment Environment) Strategy {
+	if interpreter := interpreterOf(environment); interpreter != nil {
+		return interpreter.strategy
+	}
+	return CallByName
+}
+
+var discard = log.New(io.Discard, "", 0)
+
+func isError(term Term) bool {
+	switch term.(type) {
+	case Error, UnboundVariableError, CanceledError, ExhaustedError, RaisedError:
+		return true
+	}
+	return false
+}
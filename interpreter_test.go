@@ -1,9 +1,12 @@
 package hu
 
 import (
+	"bytes"
+	"context"
 	"math/big"
 	"strings"
 	"testing"
+	"time"
 )
 
 type testCase struct {
@@ -18,6 +21,13 @@ func is_eq_number(number int64) func(Term) bool {
 	}
 }
 
+func is_number() func(Term) bool {
+	return func(result Term) bool {
+		_, ok := result.(*Number)
+		return ok
+	}
+}
+
 func is_eq(expected Term) func(Term) bool {
 	return func(result Term) bool {
 		return result == expected
@@ -58,6 +68,27 @@ func is_nil() func(Term) bool {
 	}
 }
 
+func is_raised(kind Symbol) func(Term) bool {
+	return func(result Term) bool {
+		e, ok := result.(RaisedError)
+		return ok && e.kind == kind
+	}
+}
+
+func is_exhausted() func(Term) bool {
+	return func(result Term) bool {
+		_, ok := result.(ExhaustedError)
+		return ok
+	}
+}
+
+func is_canceled() func(Term) bool {
+	return func(result Term) bool {
+		_, ok := result.(CanceledError)
+		return ok
+	}
+}
+
 var tests = []testCase{
 	{"{{lambda numbers add_numbers} 1 2 3}", is_eq_number(6)},
 
@@ -68,6 +99,9 @@ var tests = []testCase{
 	{"{concat (1 2) (3 4)}", is_tuple()},
 	{"{* 2 3 4}}", is_eq_number(24)},
 	{"{= 2 2}}", is_eq(Boolean(true))},
+	{"{= {+ 1 1} 2}", is_eq(Boolean(true))},
+	{"{begin {define two 2} {= two 2 {- 3 1}}}", is_eq(Boolean(true))},
+	{"{= {+ 1 2} 2}", is_eq(Boolean(false))},
 	{"{> 5 2}}", is_eq(Boolean(true))},
 	{"{and true true}", is_eq(Boolean(true))},
 	{"{or true false}", is_eq(Boolean(true))},
@@ -87,7 +121,30 @@ var tests = []testCase{
 	//{"{quotient 10 3}", is_eq_number(3)},
 	//{"{remainder 5 3}", is_eq_number(2)},
 	{"foo", is_unbound()},
-	{"{+ 1 foo}", is_error()},
+	{"{set foo 1}", is_unbound()},
+	{"{begin {define x 1} {define (f ()) x} {{lambda (x) {f}} 2}}", is_eq_number(1)},
+	{"{begin {define (adder (n)) {lambda (x) {+ x n}}} {{adder 3} 4}}", is_eq_number(7)},
+	{"{begin {define (f ()) {begin {define y 1} y}} {f} y}", is_unbound()},
+	{"{begin {define (f (x)) {begin {define (g ()) x} {g}}} {f 5}}", is_eq_number(5)},
+	{`{begin
+		{define (make-counter ()) {begin {define count 0} {lambda () {begin {set count {+ count 1}} count}}}}
+		{define counter nil} {set counter {make-counter}}
+		{define other nil} {set other {make-counter}}
+		{counter} {counter} {other} {counter}}`, is_eq_number(3)},
+	{"{begin {define foo 1} {{lambda (x) {set foo x}} 2} foo}", is_eq_number(2)},
+	{"{begin {define foo 1} {{lambda (x) {begin {set x 3} x}} 2}}", is_eq_number(3)},
+	{"{+ 1 foo}", is_unbound()},
+	{"{begin\n\t{define foo 1}\n\t{+ foo 1}}", is_eq_number(2)},
+	{"{begin {raise oops 1} 2}", is_raised("oops")},
+	{`{try {raise not-found "x"} (catch not-found {lambda (e) {field e value}})}`, is_eq(String("x"))},
+	{"{try {raise oops 1} (catch other {lambda (e) 1}) (catch {lambda (e) {field e kind}})}", is_eq(String("oops"))},
+	{"{try {+ 1 missing} (catch (error unbound) {lambda (e) 0})}", is_eq_number(0)},
+	{"{+ 1 {try {raise oops} (catch {lambda (e) 41})}}", is_eq_number(42)},
+	{"{try {raise oops} (catch other {lambda (e) 1})}", is_raised("oops")},
+	{"{try {+ 1 2} (catch {lambda (e) 0})}", is_eq_number(3)},
+	{"{begin {define cleaned 0} {try {raise oops} (catch {lambda (e) cleaned}) (finally {set cleaned 1})} cleaned}", is_eq_number(1)},
+	{"{begin {define cleaned 0} {try {try {raise oops} (finally {set cleaned 1})} (catch {lambda (e) cleaned})}}", is_eq_number(1)},
+	{"{try {set} (catch error {lambda (e) {field e kind}})}", is_eq(String("error"))},
 	{"{begin {define (double (x)) {+ x x}} {double 4}}", is_eq_number(8)},
 	{"{begin {define (double (x)) {+ x x}} {define (quad (x)) {+ {double x} {double x}}} {quad 4}}", is_eq_number(16)},
 	//{"{of 1 2 3}, is_eq_set({of 3 2 1})},
@@ -107,3 +164,127 @@ func TestInterpreter(t *testing.T) {
 		}
 	}
 }
+
+func TestEvaluate(t *testing.T) {
+	environment := &LocalEnvironment{}
+	AddDefaultBindings(environment)
+	if result := Evaluate(environment, Read(strings.NewReader("{+ 1 missing}"))); !is_unbound()(result) {
+		t.Errorf("expected unbound variable error, got %v", result)
+	}
+	if result := Evaluate(environment, Read(strings.NewReader("{+ 1 2}"))); !is_eq_number(3)(result) {
+		t.Errorf("expected 3, got %v", result)
+	}
+}
+
+func TestEvaluateContext(t *testing.T) {
+	loop := "{begin {define loop {lambda (n) {loop n}}} {loop 1}}"
+	evaluate := func(ctx context.Context, input string, limits Limits) Term {
+		environment := &LocalEnvironment{}
+		AddDefaultBindings(environment)
+		return EvaluateLimited(ctx, environment, Read(strings.NewReader(input)), limits)
+	}
+
+	if result := evaluate(context.Background(), loop, Limits{Steps: 1000}); !is_exhausted()(result) {
+		t.Errorf("steps: expected exhausted error, got %v", result)
+	}
+	// A loop in tail position runs in constant depth, so only a recursion
+	// that is not exhausts it.
+	deep := "{begin {define deep {lambda (n) {+ 1 {deep n}}}} {deep 1}}"
+	if result := evaluate(context.Background(), deep, Limits{Depth: 100}); !is_exhausted()(result) {
+		t.Errorf("depth: expected exhausted error, got %v", result)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if result := evaluate(ctx, loop, Limits{}); !is_canceled()(result) {
+		t.Errorf("timeout: expected canceled error, got %v", result)
+	}
+	if result := evaluate(context.Background(), "{+ 1 2}", Limits{Steps: 1000, Depth: 100}); !is_eq_number(3)(result) {
+		t.Errorf("within limits: expected 3, got %v", result)
+	}
+
+	grow := "{begin {define grow {lambda (x) {begin {set x {concat x x}} {grow x}}}} {grow (1)}}"
+	if result := evaluate(context.Background(), grow, Limits{Elements: 1 << 16}); !is_exhausted()(result) {
+		t.Errorf("elements: expected exhausted error, got %v", result)
+	}
+	square := "{begin {define square {lambda (x) {begin {set x {* x x}} {square x}}}} {square 3}}"
+	if result := evaluate(context.Background(), square, Limits{Bits: 1 << 16}); !is_exhausted()(result) {
+		t.Errorf("bits: expected exhausted error, got %v", result)
+	}
+	// A product too big is refused before it is computed.
+	if result := evaluate(context.Background(), "{* 340282366920938463463374607431768211456 340282366920938463463374607431768211456}", Limits{Bits: 200}); !is_exhausted()(result) {
+		t.Errorf("product: expected exhausted error, got %v", result)
+	}
+	for _, input := range []string{
+		`{json-parse "[1, 2, [3, 4]]"}`,
+		"{begin {define xs (1 2 3)} {quasiquote (0 {unquote-splicing xs})}}",
+		"{{lambda (rest ...) rest} 1 2 3 4}",
+		"{match (1 2 3 4) ((rest ...) rest)}",
+	} {
+		if result := evaluate(context.Background(), input, Limits{Elements: 3}); !is_exhausted()(result) {
+			t.Errorf("elements: expected %v to be exhausted, got %v", input, result)
+		}
+	}
+	if result := evaluate(context.Background(), loop, Limits{Bindings: 100}); !is_exhausted()(result) {
+		t.Errorf("bindings: expected exhausted error, got %v", result)
+	}
+
+	// A closure made in one evaluation is controlled by the evaluation
+	// that calls it.
+	interpreter := NewInterpreter()
+	interpreter.Eval("{define (spin (n)) {spin n}}")
+	if result := EvaluateLimited(context.Background(), interpreter.Environment(), Read(strings.NewReader("{spin 1}")), Limits{Steps: 1000}); !is_exhausted()(result) {
+		t.Errorf("closure: expected exhausted error, got %v", result)
+	}
+	// So is an operand left unevaluated by one evaluation and forced by
+	// a later one, after the first is canceled.
+	interpreter.Eval(`{define (count (n)) {if {< n 1} "done" {count {- n 1}}}}
+{define (delay (x)) {lambda (y) x}}
+{define later 0}`)
+	canceled, cancel := context.WithCancel(context.Background())
+	EvaluateContext(canceled, interpreter.Environment(), Read(strings.NewReader("{set later {delay {count 300}}}")))
+	cancel()
+	if result := EvaluateContext(context.Background(), interpreter.Environment(), Read(strings.NewReader("{later 0}"))); !is_eq(String("done"))(result) {
+		t.Errorf("thunk: expected done, got %v", result)
+	}
+}
+
+func TestInterpreterEmbedding(t *testing.T) {
+	var stdout bytes.Buffer
+	interpreter := NewInterpreter(WithStdout(&stdout))
+	if result := interpreter.Eval("{define (double (x)) {+ x x}}\n{double 4}"); !is_eq_number(8)(result) {
+		t.Errorf("Eval: expected 8, got %v", result)
+	}
+	interpreter.Define("n", NewNumber(big.NewRat(21, 1)))
+	if result := interpreter.Call("double", Symbol("n")); !is_eq_number(42)(result) {
+		t.Errorf("Call: expected 42, got %v", result)
+	}
+	interpreter.Eval(`{print "hello" 1}`)
+	if stdout.String() != "hello 1\n" {
+		t.Errorf("print: expected %q, got %q", "hello 1\n", stdout.String())
+	}
+	if result, err := interpreter.Load("hush/fib.hu"); err != nil || !is_eq_number(610)(result) {
+		t.Errorf("Load: expected 610, got %v %v", result, err)
+	}
+	if _, err := interpreter.Load("no such file"); err == nil {
+		t.Errorf("Load: expected an error for a missing file")
+	}
+
+	stdout.Reset()
+	program := "{{lambda (x) 1} {print 1}}"
+	NewInterpreter(WithStdout(&stdout)).Eval(program)
+	if stdout.Len() != 0 {
+		t.Errorf("CallByName: unused operand was evaluated")
+	}
+	NewInterpreter(WithStdout(&stdout), WithStrategy(CallByValue)).Eval(program)
+	if stdout.Len() == 0 {
+		t.Errorf("CallByValue: operand was not evaluated")
+	}
+
+	if result := NewInterpreter(WithPrimitives(CorePrimitives)).Eval("+"); !is_unbound()(result) {
+		t.Errorf("WithPrimitives: expected unbound variable, got %v", result)
+	}
+	limited := NewInterpreter(WithLimits(Limits{Steps: 1000}))
+	if result := limited.Eval("{begin {define loop {lambda (n) {loop n}}} {loop 1}}"); !is_exhausted()(result) {
+		t.Errorf("WithLimits: expected exhausted error, got %v", result)
+	}
+}
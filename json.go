@@ -0,0 +1,208 @@
+package hu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxExponent bounds the exponent of numbers read from JSON, as the
+// exact value of a number like 1e1000000000 is enormous.
+const maxExponent = 1000
+
+// MarshalJSON returns the JSON encoding of term. Records are encoded as
+// objects, tuples as arrays, strings and symbols as strings, booleans as
+// booleans and nil as null. A number is encoded exactly when it has a
+// finite decimal expansion, and as the nearest float64 otherwise.
+func MarshalJSON(term Term) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := marshalJSON(&buffer, term); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func marshalJSON(buffer *bytes.Buffer, term Term) error {
+	switch t := term.(type) {
+	case nil:
+		buffer.WriteString("null")
+	case Boolean:
+		buffer.WriteString(t.String())
+	case *Number:
+		buffer.WriteString(jsonNumber(t.value))
+	case String:
+		return marshalString(buffer, string(t))
+	case Symbol:
+		return marshalString(buffer, string(t))
+	case Tuple:
+		buffer.WriteByte('[')
+		for i, element := range t {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := marshalJSON(buffer, element); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	case Record:
+		buffer.WriteByte('{')
+		for i, name := range sortedNames(t) {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := marshalString(buffer, string(name)); err != nil {
+				return err
+			}
+			buffer.WriteByte(':')
+			if err := marshalJSON(buffer, t[name]); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+	default:
+		return fmt.Errorf("cannot encode %v as JSON", term)
+	}
+	return nil
+}
+
+func marshalString(buffer *bytes.Buffer, s string) error {
+	b, err := json.Marshal(s)
+	buffer.Write(b)
+	return err
+}
+
+// jsonNumber formats value exactly if its denominator has no prime
+// factors other than 2 and 5.
+func jsonNumber(value *big.Rat) string {
+	if value.IsInt() {
+		return value.Num().String()
+	}
+	denominator := new(big.Int).Set(value.Denom())
+	digits := 0
+	for _, factor := range []*big.Int{big.NewInt(2), big.NewInt(5)} {
+		n := 0
+		for new(big.Int).Rem(denominator, factor).Sign() == 0 {
+			denominator.Quo(denominator, factor)
+			n++
+		}
+		if n > digits {
+			digits = n
+		}
+	}
+	if denominator.IsInt64() && denominator.Int64() == 1 {
+		return value.FloatString(digits)
+	}
+	f, _ := value.Float64()
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// UnmarshalJSON returns the term encoded by data, the inverse of
+// MarshalJSON. Numbers are read exactly.
+func UnmarshalJSON(data []byte) (Term, error) {
+	return unmarshalJSON(data, nil)
+}
+
+// unmarshalJSON is UnmarshalJSON, charging allocate, if it is not nil,
+// for each array element and object member before it is read.
+func unmarshalJSON(data []byte, allocate func(n int)) (Term, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	term, err := readJSON(decoder, allocate)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return term, nil
+}
+
+// readJSON reads the next JSON value from decoder as a term.
+func readJSON(decoder *json.Decoder, allocate func(n int)) (Term, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	charge := func() {
+		if allocate != nil {
+			allocate(1)
+		}
+	}
+	switch t := token.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return Boolean(t), nil
+	case string:
+		return String(t), nil
+	case json.Number:
+		if i := strings.IndexAny(string(t), "eE"); i >= 0 {
+			exponent, err := strconv.Atoi(string(t[i+1:]))
+			if err != nil || exponent > maxExponent || exponent < -maxExponent {
+				return nil, fmt.Errorf("exponent of %s is out of range", t)
+			}
+		}
+		rat, ok := new(big.Rat).SetString(string(t))
+		if !ok {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return &Number{rat}, nil
+	case json.Delim:
+		switch t {
+		case '[':
+			tuple := Tuple{}
+			for decoder.More() {
+				charge()
+				element, err := readJSON(decoder, allocate)
+				if err != nil {
+					return nil, err
+				}
+				tuple = append(tuple, element)
+			}
+			_, err := decoder.Token()
+			return tuple, err
+		case '{':
+			record := make(Record)
+			for decoder.More() {
+				charge()
+				name, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				element, err := readJSON(decoder, allocate)
+				if err != nil {
+					return nil, err
+				}
+				record[Symbol(name.(string))] = element
+			}
+			_, err := decoder.Token()
+			return record, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", token)
+}
+
+func jsonParse(environment Environment, term Term) Term {
+	s, ok := evaluate(environment, term.(Tuple)[0]).(String)
+	if !ok {
+		return Error("json-parse of a term that is not a string")
+	}
+	result, err := unmarshalJSON([]byte(s), func(n int) { allocate(environment, n) })
+	if err != nil {
+		return Error("json-parse: " + err.Error())
+	}
+	return result
+}
+
+func jsonStringify(environment Environment, term Term) Term {
+	b, err := MarshalJSON(evaluate(environment, term.(Tuple)[0]))
+	if err != nil {
+		return Error("json-stringify: " + err.Error())
+	}
+	return String(b)
+}
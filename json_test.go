@@ -0,0 +1,64 @@
+package hu
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	documents := []string{
+		`null`,
+		`true`,
+		`"café"`,
+		`12345678901234567890123`,
+		`-0.1`,
+		`[1,2.5,[],"x"]`,
+		`{"a":{"b":[true,false,null]},"c":0.125}`,
+	}
+	for _, document := range documents {
+		term, err := UnmarshalJSON([]byte(document))
+		if err != nil {
+			t.Errorf("UnmarshalJSON(%s): %v", document, err)
+			continue
+		}
+		b, err := MarshalJSON(term)
+		if err != nil {
+			t.Errorf("MarshalJSON(%v): %v", term, err)
+			continue
+		}
+		if expected := strings.Replace(document, `é`, "é", 1); string(b) != expected {
+			t.Errorf("%s round tripped to %s", document, b)
+		}
+	}
+
+	if term, _ := UnmarshalJSON([]byte(`0.1`)); term.String() != "1/10" {
+		t.Errorf("expected 0.1 to be read exactly, got %v", term)
+	}
+	for _, document := range []string{`[1,`, `1 2`, `1e100000000`} {
+		if _, err := UnmarshalJSON([]byte(document)); err == nil {
+			t.Errorf("UnmarshalJSON(%s): expected an error", document)
+		}
+	}
+	if b, _ := MarshalJSON(NewNumber(big.NewRat(1, 3))); string(b) != "0.3333333333333333" {
+		t.Errorf("expected 1/3 to be encoded approximately, got %s", b)
+	}
+	if _, err := MarshalJSON(Abstraction{}); err == nil {
+		t.Errorf("expected an error encoding an abstraction")
+	}
+
+	tests := []testCase{
+		{"{field {json-parse `{\"n\": 2}`} n}", is_eq_number(2)},
+		{`{json-parse "[1, 2"}`, is_error()},
+		{`{json-stringify {record (a (1 "b"))}}`, is_eq(String(`{"a":[1,"b"]}`))},
+		{`{json-stringify {json-parse "[0.5]"}}`, is_eq(String(`[0.5]`))},
+	}
+	for _, test := range tests {
+		environment := &LocalEnvironment{}
+		AddDefaultBindings(environment)
+		result := GuardedEvaluate(environment, Read(strings.NewReader(test.input)))
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
@@ -44,6 +44,18 @@ var lexTests = []lexTest{
 			{itemWord, "onion"}, {itemPunctuation, ","}, {itemSpace, " "},
 			{itemWord, "chopped"},
 			tEOF}},
+	{"hyphen", "json-parse well- done",
+		[]item{
+			{itemWord, "json-parse"}, {itemSpace, " "},
+			{itemWord, "well"}, {itemPunctuation, "-"}, {itemSpace, " "},
+			{itemWord, "done"},
+			tEOF}},
+	{"ellipsis", "Wait... body ...",
+		[]item{
+			{itemWord, "Wait"}, {itemWord, "..."}, {itemSpace, " "},
+			{itemWord, "body"}, {itemSpace, " "},
+			{itemWord, "..."},
+			tEOF}},
 }
 
 // collect gathers the emitted items into a slice.
@@ -0,0 +1,424 @@
+package hu
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Macro is syntax defined with syntax-rules. Each rule is a tuple of a
+// pattern and a template: a use of the macro expands to the template of
+// the first rule whose pattern matches it. In patterns, _ matches
+// anything, a literal symbol matches only itself and any other symbol is
+// a pattern variable; a pattern followed by ... matches a sequence of
+// terms. Expansions are hygienic: variables bound by a template are
+// renamed, so they cannot capture the variables of the use.
+type Macro struct {
+	Literals Tuple
+	Rules    Tuple
+}
+
+func (macro Macro) String() string {
+	return "#<macro>"
+}
+
+const ellipsis = Symbol("...")
+
+// maxExpansionDepth bounds how deeply macros may expand to other macros,
+// as a macro that expands to itself would otherwise never stop.
+const maxExpansionDepth = 1000
+
+// renames numbers the variables renamed by expansions.
+var renames atomic.Int64
+
+// binding is what a pattern variable matched: a term or, under an
+// ellipsis, a sequence of bindings.
+type binding struct {
+	term     Term
+	sequence []binding
+	many     bool
+}
+
+// expand returns the expansion of form, a use of the macro, as part of
+// the evaluation environment is part of. Each expansion is charged a
+// step and the elements of the terms it builds.
+func (macro Macro) expand(environment Environment, form Application) Term {
+	if control := controlOf(environment); control != nil {
+		control.check()
+		control.step()
+	}
+	for _, r := range macro.Rules {
+		rule := r.(Tuple)
+		bindings := make(map[Symbol]binding)
+		if !macro.match(rule[0], form, bindings) {
+			continue
+		}
+		renamed := make(map[Symbol]Symbol)
+		for _, name := range binders(rule[1], nil) {
+			if _, ok := bindings[name]; !ok && name != ellipsis {
+				renamed[name] = Symbol(fmt.Sprintf("%s.%d", name, renames.Add(1)))
+			}
+		}
+		result, err := instantiate(rule[1], bindings, renamed)
+		if err != nil {
+			return Error(fmt.Sprintf("%v: %v", form[0], err))
+		}
+		allocate(environment, elements(result))
+		return result
+	}
+	return Error(fmt.Sprintf("no rule of %v matches %v", form[0], form))
+}
+
+func (macro Macro) literal(name Symbol) bool {
+	for _, literal := range macro.Literals {
+		if literal == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (macro Macro) match(pattern, term Term, bindings map[Symbol]binding) bool {
+	switch p := pattern.(type) {
+	case Symbol:
+		if p == "_" {
+			return true
+		}
+		if macro.literal(p) {
+			return term == p
+		}
+		bindings[p] = binding{term: term}
+		return true
+	case Application:
+		t, ok := term.(Application)
+		return ok && macro.matchSequence(p, t, bindings)
+	case Tuple:
+		t, ok := term.(Tuple)
+		return ok && macro.matchSequence(p, t, bindings)
+	case *Number:
+		t, ok := term.(*Number)
+		return ok && p.value.Cmp(t.value) == 0
+	case String, Boolean, Rune:
+		return term == pattern
+	}
+	return false
+}
+
+func (macro Macro) matchSequence(patterns, terms []Term, bindings map[Symbol]binding) bool {
+	repeated := -1
+	for i := 1; i < len(patterns); i++ {
+		if patterns[i] == ellipsis {
+			repeated = i - 1
+			break
+		}
+	}
+	if repeated < 0 {
+		if len(patterns) != len(terms) {
+			return false
+		}
+		for i := range patterns {
+			if !macro.match(patterns[i], terms[i], bindings) {
+				return false
+			}
+		}
+		return true
+	}
+	before, after := patterns[:repeated], patterns[repeated+2:]
+	if len(terms) < len(before)+len(after) {
+		return false
+	}
+	if !macro.matchSequence(before, terms[:len(before)], bindings) {
+		return false
+	}
+	if !macro.matchSequence(after, terms[len(terms)-len(after):], bindings) {
+		return false
+	}
+	var matches []map[Symbol]binding
+	for _, term := range terms[len(before) : len(terms)-len(after)] {
+		m := make(map[Symbol]binding)
+		if !macro.match(patterns[repeated], term, m) {
+			return false
+		}
+		matches = append(matches, m)
+	}
+	for _, name := range macro.variables(patterns[repeated], nil) {
+		b := binding{many: true}
+		for _, m := range matches {
+			b.sequence = append(b.sequence, m[name])
+		}
+		bindings[name] = b
+	}
+	return true
+}
+
+// variables appends the pattern variables of pattern to names.
+func (macro Macro) variables(pattern Term, names []Symbol) []Symbol {
+	switch p := pattern.(type) {
+	case Symbol:
+		if p != "_" && p != ellipsis && !macro.literal(p) {
+			names = append(names, p)
+		}
+	case Application:
+		for _, term := range p {
+			names = macro.variables(term, names)
+		}
+	case Tuple:
+		for _, term := range p {
+			names = macro.variables(term, names)
+		}
+	}
+	return names
+}
+
+// binders appends the variables bound by the uses of bindingForms in template
+// to names.
+func binders(template Term, names []Symbol) []Symbol {
+	var terms []Term
+	switch t := template.(type) {
+	case Application:
+		terms = t
+		if len(t) > 1 {
+			for _, form := range bindingForms {
+				if t[0] == Symbol(form.name) {
+					names = form.binders(Tuple(t[1:]), names)
+				}
+			}
+		}
+	case Tuple:
+		terms = t
+	}
+	for _, term := range terms {
+		names = binders(term, names)
+	}
+	return names
+}
+
+// nameBinders appends the name given first to a form like variable,
+// computed or shift to names.
+func nameBinders(operands Tuple, names []Symbol) []Symbol {
+	switch name := operands[0].(type) {
+	case Symbol:
+		names = append(names, name)
+	case Tuple:
+		names = symbols(name, names)
+	}
+	return names
+}
+
+// defineBinders appends the names bound by define, including the
+// parameters of a function it defines, to names.
+func defineBinders(operands Tuple, names []Symbol) []Symbol {
+	if signature, ok := operands[0].(Tuple); ok && len(signature) == 2 {
+		return parameterVariables(signature[1], symbols(signature[0], names))
+	}
+	return nameBinders(operands, names)
+}
+
+func lambdaBinders(operands Tuple, names []Symbol) []Symbol {
+	return parameterVariables(operands[0], names)
+}
+
+func operatorBinders(operands Tuple, names []Symbol) []Symbol {
+	return symbols(operands[0], names)
+}
+
+// symbols appends the symbols in term to names.
+func symbols(term Term, names []Symbol) []Symbol {
+	var terms []Term
+	switch t := term.(type) {
+	case Symbol:
+		return append(names, t)
+	case Application:
+		terms = t
+	case Tuple:
+		terms = t
+	}
+	for _, term := range terms {
+		names = symbols(term, names)
+	}
+	return names
+}
+
+// instantiate returns template with pattern variables replaced by what
+// they matched and renamed variables replaced by their new names.
+func instantiate(template Term, bindings map[Symbol]binding, renamed map[Symbol]Symbol) (Term, error) {
+	switch t := template.(type) {
+	case Symbol:
+		if b, ok := bindings[t]; ok {
+			if b.many {
+				return nil, fmt.Errorf("%v must be followed by ...", t)
+			}
+			return b.term, nil
+		}
+		if name, ok := renamed[t]; ok {
+			return name, nil
+		}
+		return t, nil
+	case Application:
+		terms, err := instantiateSequence(t, bindings, renamed)
+		return Application(terms), err
+	case Tuple:
+		terms, err := instantiateSequence(t, bindings, renamed)
+		return Tuple(terms), err
+	case Quote:
+		term, err := instantiate(t.Term, bindings, renamed)
+		return Quote{term}, err
+	}
+	return template, nil
+}
+
+func instantiateSequence(templates []Term, bindings map[Symbol]binding, renamed map[Symbol]Symbol) ([]Term, error) {
+	var terms []Term
+	for i := 0; i < len(templates); i++ {
+		if i+1 == len(templates) || templates[i+1] != ellipsis {
+			term, err := instantiate(templates[i], bindings, renamed)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, term)
+			continue
+		}
+		n := -1
+		var repeated []Symbol
+		for _, name := range symbols(templates[i], nil) {
+			if b, ok := bindings[name]; ok && b.many {
+				if n >= 0 && len(b.sequence) != n {
+					return nil, fmt.Errorf("%v matched sequences of different lengths", templates[i])
+				}
+				n = len(b.sequence)
+				repeated = append(repeated, name)
+			}
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("no pattern variable of %v matched a sequence", templates[i])
+		}
+		for j := 0; j < n; j++ {
+			inner := make(map[Symbol]binding, len(bindings))
+			for name, b := range bindings {
+				inner[name] = b
+			}
+			for _, name := range repeated {
+				inner[name] = bindings[name].sequence[j]
+			}
+			term, err := instantiate(templates[i], inner, renamed)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, term)
+		}
+		i++
+	}
+	return terms, nil
+}
+
+// Expand returns term with the uses of the macros bound in environment
+// expanded, so they are expanded once rather than each time term is
+// evaluated. Macros defined while term is evaluated are expanded when
+// their uses are.
+func Expand(environment Environment, term Term) Term {
+	return expand(environment, term, 0)
+}
+
+func expand(environment Environment, term Term, depth int) Term {
+	switch t := term.(type) {
+	case Application:
+		if len(t) == 0 {
+			return t
+		}
+		if head, ok := t[0].(Symbol); ok {
+			switch head {
+			case "define-syntax", "syntax-rules":
+				return t
+			}
+			if value, ok := environment.Get(head); ok {
+				if macro, ok := value.(Macro); ok {
+					if depth >= maxExpansionDepth {
+						return Error("macro expansion is too deep")
+					}
+					return expand(environment, macro.expand(environment, t), depth+1)
+				}
+			}
+		}
+		terms, err := expandSequence(environment, t, depth)
+		if err != nil {
+			return err
+		}
+		return Application(terms)
+	case Tuple:
+		terms, err := expandSequence(environment, t, depth)
+		if err != nil {
+			return err
+		}
+		return Tuple(terms)
+	}
+	return term
+}
+
+// expandSequence expands each of terms, returning the first error
+// instead if there is one.
+func expandSequence(environment Environment, terms []Term, depth int) ([]Term, Term) {
+	expanded := make([]Term, len(terms))
+	for i, term := range terms {
+		expanded[i] = expand(environment, term, depth)
+		if isError(expanded[i]) {
+			return nil, expanded[i]
+		}
+	}
+	return expanded, nil
+}
+
+func syntaxRules(environment Environment, term Term) Term {
+	// (literals...) (pattern template)...
+	terms := term.(Tuple)
+	if len(terms) == 0 {
+		return Error("syntax-rules needs literals and rules")
+	}
+	literals, ok := terms[0].(Tuple)
+	if !ok {
+		return Error("unexpected type for literals")
+	}
+	for _, literal := range literals {
+		if _, ok := literal.(Symbol); !ok {
+			return Error(fmt.Sprintf("literal %v is not a symbol", literal))
+		}
+	}
+	for _, r := range terms[1:] {
+		rule, ok := r.(Tuple)
+		if !ok || len(rule) != 2 {
+			return Error(fmt.Sprintf("rule %v is not a pattern and a template", r))
+		}
+		if _, ok := rule[0].(Application); !ok {
+			return Error(fmt.Sprintf("pattern %v is not an application", rule[0]))
+		}
+	}
+	return Macro{literals, terms[1:]}
+}
+
+func defineSyntax(environment Environment, term Term) Term {
+	// when {syntax-rules () ({_ test body ...} {if test {begin body ...} false})}
+	terms := term.(Tuple)
+	if len(terms) != 2 {
+		return Error("define-syntax needs a name and rules")
+	}
+	name, ok := terms[0].(Symbol)
+	if !ok {
+		return Error("unexpected type for name")
+	}
+	macro, ok := evaluate(environment, terms[1]).(Macro)
+	if !ok {
+		return Error("define-syntax of a term that is not a macro")
+	}
+	environment.Define(name, macro)
+	return nil
+}
+
+func macroexpand(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) != 1 {
+		return Error("macroexpand needs a term")
+	}
+	expanded := Expand(environment, terms[0])
+	if isError(expanded) {
+		return expanded
+	}
+	return quoted(expanded)
+}
@@ -0,0 +1,94 @@
+package hu
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMacro(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define-syntax when {syntax-rules () ({_ test body ...} {if test {begin body ...} false})}}
+{define-syntax unless {syntax-rules () ({_ test body ...} {if test false {begin body ...}})}}
+{define-syntax cond {syntax-rules (else)
+	({_ (else result)} result)
+	({_ (test result) clause ...} {if test result {cond clause ...}})}}
+{define-syntax for {syntax-rules (from to)
+	({_ i from start to end body ...} {begin {define (loop (i)) {if {> i end} true {begin body ... {loop {+ i 1}}}}} {loop start}})}}
+{define-syntax my-or {syntax-rules () ({_ a b} {begin {define t 0} {set t a} {if t t b}})}}
+{define-syntax swap {syntax-rules () ({_ a b} {begin {define tmp 0} {set tmp a} {set a b} {set b tmp}})}}
+{define-syntax forever {syntax-rules () ({_} {forever})}}
+{define (sign (x)) {cond ({< x 0} "negative") ({< x 1} "zero") (else "positive")}}`)
+
+	tests := []testCase{
+		{"{when true 1 2}", is_eq_number(2)},
+		{"{when false 1 2}", is_eq(Boolean(false))},
+		{"{unless false 3}", is_eq_number(3)},
+		{"{sign 5}", is_eq(String("positive"))},
+		{"{sign 0}", is_eq(String("zero"))},
+		{"{sign {- 0 5}}", is_eq(String("negative"))},
+		{"{begin {define total 0} {for i from 1 to 4 {set total {+ total i}}} total}", is_eq_number(10)},
+		{"{begin {define t 5} {my-or false t}}", is_eq_number(5)},
+		{"{begin {define tmp 1} {define other 2} {swap tmp other} tmp}", is_eq_number(2)},
+		{"other", is_eq_number(1)},
+		{"{when}", is_error()},
+		{"{forever}", is_error()},
+		{"{define-syntax broken {syntax-rules () (x y)}}", is_error()},
+		{"{begin {define-syntax twice {syntax-rules () ({_ e} {begin e e})}} {define n 0} {twice {set n {+ n 1}}} n}", is_eq_number(2)},
+		{"{macroexpand {unless false 3}}", func(result Term) bool {
+			return Format(result) == "'{if false false {begin 3}}"
+		}},
+		{"{eval {macroexpand {unless false 3}}}", is_eq_number(3)},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestMacroBinders(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define x 7}
+{define i 100}
+{define acc 0}
+{define-syntax first-of {syntax-rules () ({_ p e} {match p ((x y) e)})}}
+{define-syntax twice-sum {syntax-rules () ({_ e} {for i in (1 2) {set acc {+ acc e}}})}}
+{define-syntax local {syntax-rules () ({_ e} {let (((x y) (1 2))) e})}}`)
+
+	tests := []testCase{
+		{"{first-of (1 2) x}", is_eq_number(7)},
+		{"{begin {twice-sum i} acc}", is_eq_number(200)},
+		{"{local x}", is_eq_number(7)},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestMacroControl(t *testing.T) {
+	// Each expansion of m expands to two uses of m on a shorter list, so
+	// expanding a use on a list of 40 would take 2^40 expansions.
+	runaway := "{m (" + strings.Repeat("x ", 40) + ") 0}"
+	for _, limits := range []Limits{{}, {Steps: 10000, Depth: 100, Elements: 10000}} {
+		interpreter := NewInterpreter(WithLimits(limits))
+		interpreter.Eval(`{define-syntax m {syntax-rules ()
+	({_ () e} e)
+	({_ (a b ...) e} {begin {m (b ...) e} {m (b ...) e}})}}`)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		result := interpreter.Evaluate(ctx, Read(strings.NewReader(runaway)))
+		cancel()
+		if !is_exhausted()(result) && !is_canceled()(result) {
+			t.Errorf("%v: expected the expansion to be exhausted or canceled, got %v", limits, result)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%v: expansion was stopped only after %v", limits, elapsed)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package hu
+
+import (
+	"fmt"
+)
+
+// parameters is the parameter list of a function taken apart. In order,
+// a list has
+//
+//	x or a pattern         required parameters, matched as by match
+//	(x = default)          optional parameters, bound to default if not given
+//	rest ...               a rest parameter, bound to any further arguments
+//	(name: default)        keyword parameters, given as name: value
+//	(name:)                required keyword parameters
+//
+// Defaults are evaluated in the function's environment, after the given
+// arguments are bound, so they may refer to other parameters.
+type parameters struct {
+	required []Term
+	optional []Symbol
+	defaults map[Symbol]Term
+	rest     Symbol
+	keywords []Symbol
+}
+
+const keywordMarker = Symbol(":")
+
+// parseParameters takes apart a parameter list.
+func parseParameters(list Tuple) (parameters, Term) {
+	p := parameters{defaults: make(map[Symbol]Term)}
+	seen := make(map[Symbol]bool)
+	for i := 0; i < len(list); i++ {
+		var names []Symbol
+		switch {
+		case i+1 < len(list) && list[i+1] == ellipsis:
+			name, ok := list[i].(Symbol)
+			if !ok {
+				return p, Error(fmt.Sprintf("rest parameter %s is not a name", Format(list[i])))
+			}
+			if p.rest != "" || len(p.keywords) > 0 {
+				return p, Error(fmt.Sprintf("rest parameter %s after rest or keyword parameters", name))
+			}
+			p.rest, names = name, []Symbol{name}
+			i++
+		case isParameter(list[i], "="):
+			name, value := list[i].(Tuple)[0].(Symbol), list[i].(Tuple)[2]
+			if p.rest != "" || len(p.keywords) > 0 {
+				return p, Error(fmt.Sprintf("optional parameter %s after rest or keyword parameters", name))
+			}
+			p.optional, names = append(p.optional, name), []Symbol{name}
+			p.defaults[name] = value
+		case isParameter(list[i], keywordMarker):
+			parameter := list[i].(Tuple)
+			name := parameter[0].(Symbol)
+			p.keywords, names = append(p.keywords, name), []Symbol{name}
+			if len(parameter) == 3 {
+				p.defaults[name] = parameter[2]
+			}
+		default:
+			if len(p.optional) > 0 || p.rest != "" || len(p.keywords) > 0 {
+				return p, Error(fmt.Sprintf("required parameter %s after optional, rest or keyword parameters", Format(list[i])))
+			}
+			p.required, names = append(p.required, list[i]), patternVariables(list[i], nil)
+		}
+		for _, name := range names {
+			if seen[name] {
+				return p, Error(fmt.Sprintf("parameter %s is declared twice", name))
+			}
+			seen[name] = true
+		}
+	}
+	return p, nil
+}
+
+// isParameter reports whether term is an optional or keyword parameter,
+// (name marker default), or a required keyword parameter, (name:).
+func isParameter(term Term, marker Symbol) bool {
+	parameter, ok := term.(Tuple)
+	if !ok || len(parameter) < 2 || len(parameter) > 3 || parameter[1] != marker {
+		return false
+	}
+	if _, ok := parameter[0].(Symbol); !ok {
+		return false
+	}
+	return len(parameter) == 3 || marker == keywordMarker
+}
+
+// arity returns the least and greatest number of arguments that may be
+// given, the greatest being -1 if there is a rest parameter.
+func (p parameters) arity() (int, int) {
+	least := len(p.required)
+	if p.rest != "" {
+		return least, -1
+	}
+	return least, least + len(p.optional)
+}
+
+// match binds the parameters to operands, appending what each variable
+// is bound to to bindings and returning the names of the parameters left
+// to their defaults, or an error.
+func (p parameters) match(m *matcher, operands Tuple, bindings *[]bound) ([]Symbol, Term) {
+	var positional Tuple
+	given := make(map[Symbol]Term)
+	for i := 0; i < len(operands); i++ {
+		if name, ok := operands[i].(Symbol); ok && len(p.keywords) > 0 && i+2 < len(operands) && operands[i+1] == keywordMarker {
+			if !p.keyword(name) {
+				return nil, RaisedError{"keyword", String(fmt.Sprintf("unknown keyword %s", name))}
+			}
+			if _, ok := given[name]; ok {
+				return nil, RaisedError{"keyword", String(fmt.Sprintf("keyword %s given twice", name))}
+			}
+			given[name] = operands[i+2]
+			i += 2
+			continue
+		}
+		positional = append(positional, operands[i])
+	}
+	least, greatest := p.arity()
+	if len(positional) < least || greatest >= 0 && len(positional) > greatest {
+		return nil, RaisedError{"arity", String(fmt.Sprintf("expected %s, given %d", arguments(least, greatest), len(positional)))}
+	}
+	for i, pattern := range p.required {
+		if !m.operand(pattern, positional[i], bindings) {
+			return nil, m.mismatch()
+		}
+	}
+	var defaulted []Symbol
+	for i, name := range p.optional {
+		if j := len(p.required) + i; j < len(positional) {
+			*bindings = append(*bindings, bound{name, positional[j]})
+		} else {
+			defaulted = append(defaulted, name)
+		}
+	}
+	if p.rest != "" {
+		m.charge(len(positional) - len(p.required) - len(p.optional))
+		*bindings = append(*bindings, bound{p.rest, Tuple(append([]Term{}, positional[len(p.required)+len(p.optional):]...))})
+	}
+	for _, name := range p.keywords {
+		if value, ok := given[name]; ok {
+			*bindings = append(*bindings, bound{name, value})
+		} else if _, ok := p.defaults[name]; ok {
+			defaulted = append(defaulted, name)
+		} else {
+			return nil, RaisedError{"keyword", String(fmt.Sprintf("missing keyword %s", name))}
+		}
+	}
+	return defaulted, nil
+}
+
+func (p parameters) keyword(name Symbol) bool {
+	for _, keyword := range p.keywords {
+		if keyword == name {
+			return true
+		}
+	}
+	return false
+}
+
+// arguments describes the number of arguments from least to greatest.
+func arguments(least, greatest int) string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+	switch {
+	case greatest < 0:
+		return "at least " + plural(least)
+	case greatest == least:
+		return plural(least)
+	}
+	return fmt.Sprintf("%d to %s", least, plural(greatest))
+}
+
+// parameterVariables appends the variables bound by the parameter list
+// of a lambda to names.
+func parameterVariables(list Term, names []Symbol) []Symbol {
+	terms, ok := list.(Tuple)
+	if !ok {
+		return patternVariables(list, names)
+	}
+	for _, term := range terms {
+		if isParameter(term, "=") || isParameter(term, keywordMarker) {
+			names = append(names, term.(Tuple)[0].(Symbol))
+		} else {
+			names = patternVariables(term, names)
+		}
+	}
+	return names
+}
@@ -0,0 +1,50 @@
+package hu
+
+import (
+	"testing"
+)
+
+func TestParameters(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define (greet (name (greeting = "hello"))) {record (greeting greeting) (name name)}}
+{define (scale (x (factor = 2) (offset = {* factor 10}))) {+ {* x factor} offset}}
+{define (count (first rest ...)) rest}
+{define (render (text (width: 80) (title:))) {record (text text) (width width) (title title)}}
+{define (point ((x y) (z = 0))) {+ x {+ y z}}}
+{define-syntax with-default {syntax-rules () ({_ e} {{lambda ((v = e)) {= v e}}})}}`)
+
+	tests := []testCase{
+		{`{field {greet "you"} greeting}`, is_eq(String("hello"))},
+		{`{field {greet "you" "bye"} greeting}`, is_eq(String("bye"))},
+		{"{scale 3}", is_eq_number(26)},
+		{"{scale 3 1}", is_eq_number(13)},
+		{"{scale 3 1 0}", is_eq_number(3)},
+		{"{count 1 2 3}", func(result Term) bool { return Format(result) == "(2 3)" }},
+		{"{count 1}", func(result Term) bool { return Format(result) == "()" }},
+		{`{field {render "hu" title: "doc"} width}`, is_eq_number(80)},
+		{`{field {render "hu" title: "doc"} title}`, is_eq(String("doc"))},
+		{`{field {render "hu" title: "doc" width: 40} width}`, is_eq_number(40)},
+		{"{point (1 2)}", is_eq_number(3)},
+		{"{point (1 2) 3}", is_eq_number(6)},
+		{"{{lambda x x} 1 2}", func(result Term) bool { return Format(result) == "(1 2)" }},
+		{"{with-default 4}", is_eq(Boolean(true))},
+		{`{greet}`, is_raised("arity")},
+		{`{greet "a" "b" "c"}`, is_raised("arity")},
+		{"{count}", is_raised("arity")},
+		{"{try {scale} (catch arity {lambda (e) {field e value}})}", is_eq(String("expected 1 to 3 arguments, given 0"))},
+		{"{try {count} (catch arity {lambda (e) {field e value}})}", is_eq(String("expected at least 1 argument, given 0"))},
+		{`{render "hu"}`, is_raised("keyword")},
+		{`{render "hu" title: "doc" color: "red"}`, is_raised("keyword")},
+		{`{render "hu" title: "a" title: "b"}`, is_raised("keyword")},
+		{"{point (1 2 3)}", is_error()},
+		{"{{lambda ((x = 1) y) y} 2}", is_error()},
+		{"{{lambda (x x) x} 1 2}", is_error()},
+		{"{{lambda (xs ... y) y} 1 2}", is_error()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package hu
+
+import (
+	"fmt"
+)
+
+// matcher matches patterns against terms, for match clauses and for the
+// parameters of abstractions. A pattern is one of
+//
+//	_ or nil               matching anything
+//	a symbol               matching anything, and bound to it
+//	true or false          matching the boolean
+//	a number or string     matching an equal term
+//	'x                     matching the symbol or term x
+//	(p... rest ...)        matching a tuple whose elements match p..., with
+//	                       any further elements bound to rest as a tuple
+//	{record (name p)...}   matching a record with fields that match p...
+//	{set p...}             matching a set whose elements each match one p
+//
+// If force is not nil, a term matched against a pattern that is not a
+// binder is first replaced by force(term), unless it is part of a term
+// already forced; the parts of a forced term are bound quoted, as data.
+// If allocate is not nil, it is charged for the tuples built for rest
+// patterns.
+type matcher struct {
+	force    func(Term) Term
+	allocate func(n int)
+	// forced is whether the term being matched is part of a forced term.
+	forced bool
+	// pattern and term are the innermost pattern and term that did not
+	// match.
+	pattern, term Term
+}
+
+// bound is a variable bound by a match and the term it is bound to.
+// Bindings are kept in the order of the pattern, which is the order
+// their terms are evaluated in.
+type bound struct {
+	name Symbol
+	term Term
+}
+
+func (m *matcher) match(pattern, term Term, bindings *[]bound) bool {
+	if name, ok := pattern.(Symbol); ok && name != "true" && name != "false" {
+		if name != "_" {
+			if m.forced {
+				term = quoted(term)
+			}
+			*bindings = append(*bindings, bound{name, term})
+		}
+		return true
+	}
+	if pattern == nil {
+		return true
+	}
+	if m.force != nil && !m.forced {
+		if _, ok := term.(Tuple); !ok {
+			m.forced = true
+			defer func() { m.forced = false }()
+		}
+		term = m.force(term)
+	}
+	if !m.matchStructure(pattern, term, bindings) {
+		if m.pattern == nil {
+			m.pattern, m.term = pattern, term
+		}
+		return false
+	}
+	return true
+}
+
+// operand matches pattern against term, a single operand. The operands
+// of an operator come as tuples of operands, which are matched element
+// by element, but a tuple given as one operand is data, like the subject
+// of a match, and its parts are bound quoted.
+func (m *matcher) operand(pattern, term Term, bindings *[]bound) bool {
+	if _, ok := term.(Tuple); ok && m.force != nil && !m.forced {
+		m.forced = true
+		defer func() { m.forced = false }()
+	}
+	return m.match(pattern, term, bindings)
+}
+
+func (m *matcher) matchStructure(pattern, term Term, bindings *[]bound) bool {
+	switch p := pattern.(type) {
+	case Symbol:
+		return equal(Boolean(p == "true"), term)
+	case Tuple:
+		t, ok := term.(Tuple)
+		if !ok {
+			return false
+		}
+		if n := len(p); n >= 2 && p[n-1] == ellipsis {
+			rest, ok := p[n-2].(Symbol)
+			if !ok || len(t) < n-2 || !m.matchAll(p[:n-2], t[:n-2], bindings) {
+				return false
+			}
+			if rest != "_" {
+				m.charge(len(t) - (n - 2))
+				*bindings = append(*bindings, bound{rest, Tuple(append([]Term{}, t[n-2:]...))})
+			}
+			return true
+		}
+		return len(p) == len(t) && m.matchAll(p, t, bindings)
+	case Application:
+		if len(p) == 0 {
+			return false
+		}
+		switch p[0] {
+		case Symbol("record"):
+			return m.matchRecord(p[1:], term, bindings)
+		case Symbol("set"):
+			s, ok := term.(Set)
+			return ok && len(s) == len(p)-1 && m.matchSet(p[1:], s, make([]bool, len(s)), bindings)
+		}
+		return false
+	}
+	return equal(pattern, term)
+}
+
+func (m *matcher) matchAll(patterns, terms []Term, bindings *[]bound) bool {
+	for i := range patterns {
+		if !m.match(patterns[i], terms[i], bindings) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *matcher) matchRecord(fields []Term, term Term, bindings *[]bound) bool {
+	record, ok := term.(Record)
+	if !ok {
+		return false
+	}
+	for _, f := range fields {
+		field, ok := f.(Tuple)
+		if !ok || len(field) != 2 {
+			return false
+		}
+		name, ok := field[0].(Symbol)
+		if !ok {
+			return false
+		}
+		value, ok := record[name]
+		if !ok || !m.match(field[1], value, bindings) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSet matches each of patterns against a different element of set
+// not already used, trying the elements in turn.
+func (m *matcher) matchSet(patterns []Term, set Set, used []bool, bindings *[]bound) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for i, element := range set {
+		if used[i] {
+			continue
+		}
+		attempt := append([]bound{}, *bindings...)
+		used[i] = true
+		if m.match(patterns[0], element, &attempt) && m.matchSet(patterns[1:], set, used, &attempt) {
+			*bindings = attempt
+			return true
+		}
+		used[i] = false
+	}
+	return false
+}
+
+// patternVariables appends the variables bound by pattern to names.
+func patternVariables(pattern Term, names []Symbol) []Symbol {
+	switch p := pattern.(type) {
+	case Symbol:
+		switch p {
+		case "_", "true", "false", ellipsis:
+		default:
+			names = append(names, p)
+		}
+	case Tuple:
+		for _, term := range p {
+			names = patternVariables(term, names)
+		}
+	case Application:
+		if len(p) == 0 {
+			break
+		}
+		switch p[0] {
+		case Symbol("record"):
+			for _, f := range p[1:] {
+				if field, ok := f.(Tuple); ok && len(field) == 2 {
+					names = patternVariables(field[1], names)
+				}
+			}
+		case Symbol("set"):
+			for _, term := range p[1:] {
+				names = patternVariables(term, names)
+			}
+		}
+	}
+	return names
+}
+
+func (m *matcher) charge(n int) {
+	if m.allocate != nil {
+		m.allocate(n)
+	}
+}
+
+// mismatch returns an error describing why the last match failed.
+func (m *matcher) mismatch() Term {
+	return Error(fmt.Sprintf("%s does not match %s", Format(m.term), Format(m.pattern)))
+}
+
+// equal reports whether a and b are equal data: numbers with the same
+// value, the same strings, booleans or runes, the same symbol, quoted or
+// not, or tuples of equal elements.
+func equal(a, b Term) bool {
+	a, b = datum(a), datum(b)
+	switch x := a.(type) {
+	case *Number:
+		y, ok := b.(*Number)
+		return ok && x.value.Cmp(y.value) == 0
+	case String, Boolean, Rune, Symbol:
+		return a == b
+	case Tuple:
+		y, ok := b.(Tuple)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// matchBinders appends the variables bound by the clauses of a match to
+// names.
+func matchBinders(operands Tuple, names []Symbol) []Symbol {
+	for _, c := range operands[1:] {
+		if clause, ok := c.(Tuple); ok && len(clause) > 0 {
+			names = patternVariables(clause[0], names)
+		}
+	}
+	return names
+}
+
+func matchPrimitive(environment Environment, term Term) Term {
+	// subject (pattern body...) (pattern when guard body...)...
+	terms := term.(Tuple)
+	if len(terms) == 0 {
+		return Error("match needs a subject")
+	}
+	subject := evaluate(environment, terms[0])
+	m := &matcher{allocate: func(n int) { allocate(environment, n) }}
+	for _, c := range terms[1:] {
+		clause, ok := c.(Tuple)
+		if !ok || len(clause) < 2 {
+			return Error(fmt.Sprintf("clause %s is not a pattern and a body", Format(c)))
+		}
+		var bindings []bound
+		if !m.match(clause[0], subject, &bindings) {
+			continue
+		}
+		frame := newFrame(environment)
+		for _, b := range bindings {
+			frame.Define(b.name, quoted(b.term))
+		}
+		body := clause[1:]
+		if body[0] == Symbol("when") {
+			if len(body) < 3 {
+				return Error(fmt.Sprintf("clause %s has a guard but no body", Format(c)))
+			}
+			if guard, ok := evaluate(frame, body[1]).(Boolean); !ok || !bool(guard) {
+				continue
+			}
+			body = body[2:]
+		}
+		for _, expression := range body[:len(body)-1] {
+			evaluate(frame, expression)
+		}
+		return Closure{body[len(body)-1], frame}
+	}
+	return RaisedError{"no-match", quoted(subject)}
+}
@@ -0,0 +1,65 @@
+package hu
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Define("colors", Set{String("red"), String("green")})
+	interpreter.Eval(`{define (describe (x)) {match x
+	(0 "zero")
+	("hu" "a language")
+	(true "yes")
+	('point "a symbol")
+	((a b) when {< a b} "ascending pair")
+	((a b) "pair")
+	((first rest ...) rest)
+	({record (name n)} n)
+	(_ "something else")}}
+{define (difference ((a b))) {- a b}}`)
+
+	tests := []testCase{
+		{"{describe 0}", is_eq(String("zero"))},
+		{`{describe "hu"}`, is_eq(String("a language"))},
+		{"{describe true}", is_eq(String("yes"))},
+		{"{describe 'point}", is_eq(String("a symbol"))},
+		{"{describe (1 2)}", is_eq(String("ascending pair"))},
+		{"{describe (2 1)}", is_eq(String("pair"))},
+		{"{describe (1 2 3)}", func(result Term) bool { return Format(result) == "(2 3)" }},
+		{`{describe {record (name "hu") (age 12)}}`, is_eq(String("hu"))},
+		{"{describe 5}", is_eq(String("something else"))},
+		{"{match '(a b) ((x y) y)}", func(result Term) bool { return Format(result) == "'b" }},
+		{"{match (1 2) ((x y) {define z {+ x y}} {* z 2})}", is_eq_number(6)},
+		{`{match colors ({set "green" c} c)}`, is_eq(String("red"))},
+		{"{match 1 (2 3)}", is_raised("no-match")},
+		{"{try {match 1 (2 3)} (catch no-match {lambda (e) {field e value}})}", is_eq_number(1)},
+		{"{difference (5 2)}", is_eq_number(3)},
+		{"{begin {define pair (7 4)} {difference pair}}", is_eq_number(3)},
+		{"{difference (1 2 3)}", is_error()},
+		{"{difference '(a b)}", is_error()},
+		{"{begin {define (fst ((x y))) x} {fst '(a b)}}", func(result Term) bool { return Format(result) == "'a" }},
+		{"{begin {define (fst ((x y))) x} {fst ({+ 1 2} 4)}}", func(result Term) bool { return Format(result) == "'{+ 1 2}" }},
+		{"{{lambda (x y) x} 1}", is_raised("arity")},
+		{"{{lambda (x rest ...) rest} 1 2 3}", func(result Term) bool { return Format(result) == "(2 3)" }},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestBindingOrder(t *testing.T) {
+	interpreter := NewInterpreter(WithStrategy(CallByValue))
+	interpreter.Eval(`{define order 0}
+{define (note (n)) {set order {+ {* order 10} n}}}`)
+	for i := 0; i < 10; i++ {
+		interpreter.Eval("{set order 0}")
+		interpreter.Eval("{{lambda (a b c) 0} {note 1} {note 2} {note 3}}")
+		if result := interpreter.Eval("order"); !is_eq_number(123)(result) {
+			t.Fatalf("operands were evaluated in the order %v", result)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package hu
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// PersistentEnvironment is an Environment whose bindings are held in a
+// persistent hash array mapped trie. Defining a variable makes a new trie
+// that shares all but one path with the old one, so Fork is O(1) and
+// definitions in a fork do not affect the environment it was forked from,
+// nor the reverse. Functions defined in an environment before it was
+// forked see the fork's bindings when they are applied in the fork. It is
+// safe for concurrent use by multiple goroutines.
+type PersistentEnvironment struct {
+	root atomic.Pointer[trie]
+
+	// origin is the environment this one was forked from, if any.
+	origin *PersistentEnvironment
+}
+
+func NewPersistentEnvironment() *PersistentEnvironment {
+	environment := &PersistentEnvironment{}
+	environment.root.Store(&trie{})
+	return environment
+}
+
+// Fork returns a new environment with the bindings environment has now.
+func (environment *PersistentEnvironment) Fork() *PersistentEnvironment {
+	fork := &PersistentEnvironment{origin: environment}
+	fork.root.Store(environment.root.Load())
+	return fork
+}
+
+// rebase returns the environment an abstraction closed over environment
+// extends when applied in caller. If environment is a PersistentEnvironment
+// and caller is in a fork of it, the fork stands in for it.
+func rebase(environment, caller Environment) Environment {
+	origin, ok := unwrap(environment).(*PersistentEnvironment)
+	if !ok {
+		return environment
+	}
+	for frame := caller; frame != nil; frame = Parent(frame) {
+		if fork, ok := unwrap(frame).(*PersistentEnvironment); ok {
+			for f := fork.origin; f != nil && fork != origin; f = f.origin {
+				if f == origin {
+					return fork
+				}
+			}
+			break
+		}
+	}
+	return environment
+}
+
+// unwrap returns the environment environment wraps, if any.
+func unwrap(environment Environment) Environment {
+	for wrapper, ok := environment.(wrappedEnvironment); ok; wrapper, ok = environment.(wrappedEnvironment) {
+		environment = wrapper.wrapped()
+	}
+	return environment
+}
+
+func (environment *PersistentEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *PersistentEnvironment) Define(variable Symbol, value Term) {
+	hash := hashOf(variable)
+	for {
+		root := environment.root.Load()
+		if environment.root.CompareAndSwap(root, root.put(variable, value, hash, 0)) {
+			return
+		}
+	}
+}
+
+func (environment *PersistentEnvironment) Set(variable Symbol, value Term) bool {
+	hash := hashOf(variable)
+	for {
+		root := environment.root.Load()
+		if _, ok := root.get(variable, hash, 0); !ok {
+			return false
+		}
+		if environment.root.CompareAndSwap(root, root.put(variable, value, hash, 0)) {
+			return true
+		}
+	}
+}
+
+func (environment *PersistentEnvironment) Get(variable Symbol) (Term, bool) {
+	value, ok := environment.root.Load().get(variable, hashOf(variable), 0)
+	if ok {
+		return value, ok
+	} else {
+		return UnboundVariableError{variable, "get"}, false
+	}
+}
+
+// snapshot returns a copy of the bindings in environment.
+func (environment *PersistentEnvironment) snapshot() map[Symbol]Term {
+	bindings := make(map[Symbol]Term)
+	environment.root.Load().each(func(name Symbol, value Term) {
+		bindings[name] = value
+	})
+	return bindings
+}
+
+// trie is a node of a hash array mapped trie. Each level consumes five
+// bits of the hash, and bitmap records which of the 32 possible entries
+// are present. Below the last level entries with the same hash are kept
+// in a list.
+type trie struct {
+	bitmap  uint32
+	entries []entry
+}
+
+// entry is either a binding or, if node is not nil, a subtrie.
+type entry struct {
+	name  Symbol
+	value Term
+	node  *trie
+}
+
+const levelBits = 5
+
+// hashOf is the 32-bit FNV-1a hash of name.
+func hashOf(name Symbol) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
+func (node *trie) get(name Symbol, hash uint32, shift uint) (Term, bool) {
+	for shift < 32 {
+		bit := uint32(1) << (hash >> shift & (1<<levelBits - 1))
+		if node.bitmap&bit == 0 {
+			return nil, false
+		}
+		e := node.entries[bits.OnesCount32(node.bitmap&(bit-1))]
+		if e.node == nil {
+			if e.name == name {
+				return e.value, true
+			}
+			return nil, false
+		}
+		node, shift = e.node, shift+levelBits
+	}
+	for _, e := range node.entries {
+		if e.name == name {
+			return e.value, true
+		}
+	}
+	return nil, false
+}
+
+// put returns a trie like node, but with name bound to value.
+func (node *trie) put(name Symbol, value Term, hash uint32, shift uint) *trie {
+	if shift >= 32 {
+		for i, e := range node.entries {
+			if e.name == name {
+				return node.with(i, entry{name: name, value: value})
+			}
+		}
+		entries := make([]entry, len(node.entries), len(node.entries)+1)
+		copy(entries, node.entries)
+		return &trie{entries: append(entries, entry{name: name, value: value})}
+	}
+	bit := uint32(1) << (hash >> shift & (1<<levelBits - 1))
+	i := bits.OnesCount32(node.bitmap & (bit - 1))
+	if node.bitmap&bit == 0 {
+		entries := make([]entry, len(node.entries)+1)
+		copy(entries, node.entries[:i])
+		entries[i] = entry{name: name, value: value}
+		copy(entries[i+1:], node.entries[i:])
+		return &trie{node.bitmap | bit, entries}
+	}
+	switch e := node.entries[i]; {
+	case e.node != nil:
+		return node.with(i, entry{node: e.node.put(name, value, hash, shift+levelBits)})
+	case e.name == name:
+		return node.with(i, entry{name: name, value: value})
+	default:
+		child := (&trie{}).put(e.name, e.value, hashOf(e.name), shift+levelBits)
+		return node.with(i, entry{node: child.put(name, value, hash, shift+levelBits)})
+	}
+}
+
+// with returns a copy of node with its i'th entry replaced by e.
+func (node *trie) with(i int, e entry) *trie {
+	entries := make([]entry, len(node.entries))
+	copy(entries, node.entries)
+	entries[i] = e
+	return &trie{node.bitmap, entries}
+}
+
+func (node *trie) each(f func(Symbol, Term)) {
+	for _, e := range node.entries {
+		if e.node != nil {
+			e.node.each(f)
+		} else {
+			f(e.name, e.value)
+		}
+	}
+}
@@ -4,28 +4,86 @@ import (
 	"math/big"
 
 	"fmt"
-	"log"
+	"io"
 )
 
+// makeNumber makes a Number computed by a primitive, charging its size
+// to the evaluation environment is part of.
+func makeNumber(environment Environment, value *big.Rat) *Number {
+	if control := controlOf(environment); control != nil {
+		control.measure(value)
+	}
+	return &Number{value}
+}
+
+// allocate charges n tuple elements to the evaluation environment is
+// part of; it is called before the elements are built.
+func allocate(environment Environment, n int) {
+	if control := controlOf(environment); control != nil {
+		control.allocate(n)
+	}
+}
+
+// elements returns the number of tuple elements, application terms and
+// record fields in term, as charged by allocate.
+func elements(term Term) int {
+	n := 0
+	switch t := term.(type) {
+	case Application:
+		n += len(t)
+		for _, element := range t {
+			n += elements(element)
+		}
+	case Tuple:
+		n += len(t)
+		for _, element := range t {
+			n += elements(element)
+		}
+	case Record:
+		n += len(t)
+		for _, value := range t {
+			n += elements(value)
+		}
+	}
+	return n
+}
+
+// measureProduct checks, before they are multiplied or divided, that
+// the product of a and b would not be too big for the evaluation
+// environment is part of.
+func measureProduct(environment Environment, a, b *big.Rat) {
+	if control := controlOf(environment); control != nil {
+		control.measureBits(a.Num().BitLen() + a.Denom().BitLen() + b.Num().BitLen() + b.Denom().BitLen())
+	}
+}
+
+// bind charges n variable bindings to the evaluation environment is
+// part of.
+func bind(environment Environment, n int) {
+	if control := controlOf(environment); control != nil {
+		control.bind(n)
+	}
+}
+
 func lambda(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	parameters := Tuple([]Term{nil, terms[0]})
 	//parameters := Tuple([]Term{nil, Tuple([]Term{terms[0]})})
 	term = terms[1]
-	return Abstraction{parameters, term}
+	return Abstraction{parameters, term, environment}
 }
 
 func operator(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	parameters := terms[0]
 	term = terms[1]
-	return Abstraction{parameters, term}
+	return Abstraction{parameters, term, environment}
 }
 
 func add_numbers(environment Environment, term Term) Term {
 	var result = big.NewRat(0, 1)
-	for i, argument := range Evaluate(environment, term).(Tuple) {
-		num, ok := Evaluate(environment, argument).(*Number)
+	for i, argument := range evaluate(environment, term).(Tuple) {
+		num, ok := evaluate(environment, argument).(*Number)
 		if ok {
 			result.Add(result, num.value)
 		} else {
@@ -33,74 +91,116 @@ func add_numbers(environment Environment, term Term) Term {
 			return Error(error)
 		}
 	}
-	return &Number{result}
+	return makeNumber(environment, result)
 }
 
 func add_numbersP(environment Environment) Term {
 	var result = big.NewRat(0, 1)
 	numbersExp, _ := environment.Get(Symbol("numbers"))
-	numbers := Evaluate(environment, numbersExp)
+	numbers := evaluate(environment, numbersExp)
 	for _, number := range numbers.(Tuple) {
-		num := Evaluate(environment, number).(*Number)
+		num := evaluate(environment, number).(*Number)
 		result.Add(result, num.value)
 	}
-	return &Number{result}
+	return makeNumber(environment, result)
 }
 
 func add_lists(environment Environment, arguments Term) Term {
-	var terms []Term
+	var lists []Tuple
+	var n int
 	for _, argument := range arguments.(Tuple) {
-		for _, term := range Evaluate(environment, argument).(Tuple) {
-			terms = append(terms, term)
-		}
+		list := evaluate(environment, argument).(Tuple)
+		lists = append(lists, list)
+		n += len(list)
+	}
+	allocate(environment, n)
+	terms := make([]Term, 0, n)
+	for _, list := range lists {
+		terms = append(terms, list...)
 	}
 	return Tuple(terms)
 }
 
+func record(environment Environment, term Term) Term {
+	fields := term.(Tuple)
+	allocate(environment, len(fields))
+	result := make(Record, len(fields))
+	for _, field := range fields {
+		f := field.(Tuple)
+		result[f[0].(Symbol)] = evaluate(environment, f[1])
+	}
+	return result
+}
+
+func setOf(environment Environment, term Term) Term {
+	elements := term.(Tuple)
+	allocate(environment, len(elements))
+	set := make(Set, len(elements))
+	for i, element := range elements {
+		set[i] = evaluate(environment, element)
+	}
+	return set
+}
+
+func field(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	record, ok := evaluate(environment, terms[0]).(Record)
+	if !ok {
+		return Error("field of a term that is not a record")
+	}
+	name := terms[1].(Symbol)
+	value, ok := record[name]
+	if !ok {
+		return UnboundVariableError{name, "field"}
+	}
+	return value
+}
+
 func subtract_proc(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	// TODO: implement uniary negation
-	num := Evaluate(environment, terms[0]).(*Number)
+	num := evaluate(environment, terms[0]).(*Number)
 	result := big.NewRat(0, 1).Set(num.value)
 	for _, argument := range terms[1:] {
-		num = Evaluate(environment, argument).(*Number)
+		num = evaluate(environment, argument).(*Number)
 		result.Sub(result, num.value)
 	}
-	return &Number{result}
+	return makeNumber(environment, result)
 }
 
 func multiply_proc(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	var result = big.NewRat(1, 1)
-	log.Println(fmt.Sprintf("mult %#v\n", term))
 	for _, argument := range terms {
-		log.Println(fmt.Sprintf("'%#v'", argument))
-		result.Mul(result, argument.(*Number).value)
+		num := evaluate(environment, argument).(*Number)
+		measureProduct(environment, result, num.value)
+		result.Mul(result, num.value)
 	}
-	return &Number{result}
+	return makeNumber(environment, result)
 }
 
 func quotient_proc(environment Environment, term Term) Term {
 	terms := term.(Tuple)
-	a := Evaluate(environment, terms[0]).(*Number)
-	b := Evaluate(environment, terms[1]).(*Number)
+	a := evaluate(environment, terms[0]).(*Number)
+	b := evaluate(environment, terms[1]).(*Number)
+	measureProduct(environment, a.value, b.value)
 	result := big.NewRat(0, 1).Quo(a.value, b.value)
-	return &Number{result}
+	return makeNumber(environment, result)
 }
 
 // func remainder_proc(environment Environment, term Term) Term {
 // 	terms := term.(Tuple)
-// 	a := Evaluate(environment, terms[0]).(*Number)
-// 	b := Evaluate(environment, terms[1]).(*Number)
+// 	a := evaluate(environment, terms[0]).(*Number)
+// 	b := evaluate(environment, terms[1]).(*Number)
 // 	result := big.NewRat(0, 1).Rem(a.value, b.value)
 // 	return &Number{result}
 // }
 
 func is_number_equal_proc(environment Environment, term Term) Term {
 	terms := term.(Tuple)
-	value := terms[0].(*Number).value
+	value := evaluate(environment, terms[0]).(*Number).value
 	for _, argument := range terms[1:] {
-		num := Evaluate(environment, argument).(*Number)
+		num := evaluate(environment, argument).(*Number)
 		if value.Cmp(num.value) != 0 {
 			return Boolean(false)
 		}
@@ -110,10 +210,10 @@ func is_number_equal_proc(environment Environment, term Term) Term {
 
 func is_less_than_proc(environment Environment, term Term) Term {
 	terms := term.(Tuple)
-	num := Evaluate(environment, terms[0])
+	num := evaluate(environment, terms[0])
 	previous := num.(*Number).value
 	for _, argument := range terms[1:] {
-		num = Evaluate(environment, argument)
+		num = evaluate(environment, argument)
 		next := num.(*Number).value
 		if previous.Cmp(next) == -1 {
 			previous = next
@@ -126,10 +226,10 @@ func is_less_than_proc(environment Environment, term Term) Term {
 
 func is_greater_than_proc(environment Environment, term Term) Term {
 	terms := term.(Tuple)
-	num := Evaluate(environment, terms[0])
+	num := evaluate(environment, terms[0])
 	previous := num.(*Number).value
 	for _, argument := range terms[1:] {
-		num = Evaluate(environment, argument)
+		num = evaluate(environment, argument)
 		next := num.(*Number).value
 		if previous.Cmp(next) == 1 {
 			previous = next
@@ -155,11 +255,11 @@ func define(environment Environment, term Term) Term {
 		parameters := v[1]
 		body := terms[1]
 		value = lambda(environment, Tuple([]Term{parameters, body}))
-		//TODO: value = Closure{value, environment}
 	default:
 		panic("unexpected type")
 
 	}
+	bind(environment, 1)
 	environment.Define(variable, value)
 	return nil
 }
@@ -172,25 +272,37 @@ func variable(environment Environment, term Term) Term {
 	if !ok {
 		return Error("unexpected type for name")
 	}
-	didSet, ok := Evaluate(environment, terms[1]).(Abstraction)
-	if !ok {
-		return Error("unexpected type for didSet")
+	property := &Property{Name: name}
+	if len(terms) > 1 {
+		didSet, ok := evaluate(environment, terms[1]).(Abstraction)
+		if !ok {
+			return Error("unexpected type for didSet")
+		}
+		property.DidSet = didSet
 	}
-	environment.Define(name, &Property{name, didSet})
-	environment.Define(Symbol(name+"^didSet"), didSet)
+	bind(environment, 1)
+	environment.Define(name, property)
 	return nil
 }
 
 func set(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	variable := terms[0]
-	value := Evaluate(environment, terms[1])
-	name := variable.(Symbol)
-	environment.Set(name, value)
-	didSet, ok := environment.Get(Symbol(name + "^didSet"))
-	log.Println("didSet", didSet, ok)
-	if ok {
-		Evaluate(environment, Application([]Term{didSet, value}))
+	value := evaluate(environment, terms[1])
+	return assign(environment, variable.(Symbol), value)
+}
+
+// assign sets the variable or property name to value, as set does.
+func assign(environment Environment, name Symbol, value Term) Term {
+	old, _ := environment.Get(name)
+	if property, ok := old.(*Property); ok {
+		return property.set(environment, value)
+	}
+	if !environment.Set(name, value) {
+		return UnboundVariableError{name, "set"}
+	}
+	if transaction := transactionOf(environment); transaction != nil {
+		transaction.record(change{environment: environment, name: name, old: old})
 	}
 	return nil
 }
@@ -199,6 +311,9 @@ func get(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	variable := terms[0]
 	value, ok := environment.Get(variable.(Symbol))
+	if property, isProperty := value.(*Property); isProperty {
+		return property.get(environment)
+	}
 	if ok {
 		return value
 	} else {
@@ -206,18 +321,33 @@ func get(environment Environment, term Term) Term {
 	}
 }
 
-func begin(environment Environment, term Term) Term {
-	var result Term
-	for _, expression := range term.(Tuple) {
-		result = Evaluate(environment, expression)
+func printPrimitive(environment Environment, term Term) Term {
+	return write(stdoutOf(environment), environment, term)
+}
+
+func warnPrimitive(environment Environment, term Term) Term {
+	return write(stderrOf(environment), environment, term)
+}
+
+func write(w io.Writer, environment Environment, term Term) Term {
+	var values []interface{}
+	for _, argument := range term.(Tuple) {
+		values = append(values, evaluate(environment, argument))
 	}
-	return result
+	if _, err := fmt.Fprintln(w, values...); err != nil {
+		return Error(err.Error())
+	}
+	return nil
+}
+
+func begin(environment Environment, term Term) Term {
+	return sequence(environment, term.(Tuple))
 }
 
 func and(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	for _, exp := range terms {
-		result := Evaluate(environment, exp).(Boolean)
+		result := evaluate(environment, exp).(Boolean)
 		if !result {
 			return result
 		}
@@ -228,7 +358,7 @@ func and(environment Environment, term Term) Term {
 func or(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	for _, exp := range terms {
-		result := Evaluate(environment, exp).(Boolean)
+		result := evaluate(environment, exp).(Boolean)
 		if result {
 			return result
 		}
@@ -239,7 +369,7 @@ func or(environment Environment, term Term) Term {
 func ifPrimitive(environment Environment, term Term) Term {
 	terms := term.(Tuple)
 	if_predicate := terms[0]
-	if Evaluate(environment, if_predicate).(Boolean) {
+	if evaluate(environment, if_predicate).(Boolean) {
 		if_consequent := terms[1]
 		term = if_consequent
 	} else {
@@ -251,7 +381,7 @@ func ifPrimitive(environment Environment, term Term) Term {
 		}
 		term = if_alternative
 	}
-	return Evaluate(environment, term)
+	return term
 }
 
 func apply(environment Environment, term Term) Term {
@@ -259,25 +389,5 @@ func apply(environment Environment, term Term) Term {
 }
 
 func evalPrimitive(environment Environment, term Term) Term {
-	return Evaluate(environment, term.(Tuple)[0])
-}
-
-func let(environment Environment, term Term) Term {
-	terms := term.(Tuple)
-	bindings := terms[0]
-	body := terms[1]
-
-	var parameters, arguments Tuple
-	for _, binding := range bindings.(Tuple) {
-		b := binding.(Tuple)
-		parameters = append(parameters, b[0])
-		arguments = append(arguments, b[1])
-	}
-
-	parameters = Tuple([]Term{parameters}) // TODO: ??
-
-	operator := lambda(environment, Tuple([]Term{parameters, body}))
-	operands := arguments
-
-	return Application([]Term{operator, operands})
+	return datum(evaluate(environment, term.(Tuple)[0]))
 }
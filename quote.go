@@ -0,0 +1,143 @@
+package hu
+
+import (
+	"strings"
+)
+
+// Quote is a term quoted as data: it evaluates to itself rather than to
+// the value of Term. Only terms that would otherwise be evaluated are
+// quoted; quoting a number, string or tuple yields the term itself.
+type Quote struct {
+	Term Term
+}
+
+func (quote Quote) String() string {
+	return "'" + Format(quote.Term)
+}
+
+// quoted returns term as data.
+func quoted(term Term) Term {
+	switch term.(type) {
+	case Reducible, Quote:
+		return Quote{term}
+	}
+	return term
+}
+
+// datum returns the term value is data for.
+func datum(value Term) Term {
+	if quote, ok := value.(Quote); ok {
+		return quote.Term
+	}
+	return value
+}
+
+// Format returns the syntax of term, which Read reads back as the same
+// term, except that records and sets are written as the record and
+// set-of forms that evaluate to them. Unlike String, it quotes strings.
+// Templates are written with the quasiquote, unquote and
+// unquote-splicing forms, as the reader has no shorthand for them.
+func Format(term Term) string {
+	switch t := term.(type) {
+	case nil:
+		return "nil"
+	case String:
+		s := string(t)
+		if strings.Contains(s, `"`) && !strings.ContainsAny(s, "`\n\\") {
+			return "`" + s + "`"
+		}
+		return `"` + escaper.Replace(s) + `"`
+	case Tuple:
+		return "(" + formatAll(t) + ")"
+	case Application:
+		return "{" + formatAll(t) + "}"
+	case Set:
+		return "{" + formatAll(append(Tuple{Symbol("set-of")}, t...)) + "}"
+	}
+	return term.String()
+}
+
+// escaper escapes a string for reading back between double quotes.
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+
+func formatAll(terms []Term) string {
+	formatted := make([]string, len(terms))
+	for i, term := range terms {
+		formatted[i] = Format(term)
+	}
+	return strings.Join(formatted, " ")
+}
+
+func quote(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) != 1 {
+		return Error("quote needs a term")
+	}
+	return quoted(terms[0])
+}
+
+func quasiquote(environment Environment, term Term) Term {
+	// (1 {unquote x} {unquote-splicing xs})
+	terms := term.(Tuple)
+	if len(terms) != 1 {
+		return Error("quasiquote needs a template")
+	}
+	result, err := unquote(environment, terms[0], 0)
+	if err != nil {
+		return err
+	}
+	return quoted(result)
+}
+
+// unquote returns template with the values of the terms unquoted at
+// depth 0 in place of them. Each quasiquote nested in template is one
+// deeper.
+func unquote(environment Environment, template Term, depth int) (Term, Term) {
+	switch t := template.(type) {
+	case Application:
+		if len(t) == 2 {
+			switch t[0] {
+			case Symbol("unquote"):
+				if depth == 0 {
+					return datum(evaluate(environment, t[1])), nil
+				}
+				inner, err := unquote(environment, t[1], depth-1)
+				return Application{t[0], inner}, err
+			case Symbol("quasiquote"):
+				inner, err := unquote(environment, t[1], depth+1)
+				return Application{t[0], inner}, err
+			}
+		}
+		terms, err := unquoteSequence(environment, t, depth)
+		return Application(terms), err
+	case Tuple:
+		terms, err := unquoteSequence(environment, t, depth)
+		return Tuple(terms), err
+	case Quote:
+		inner, err := unquote(environment, t.Term, depth)
+		return quoted(inner), err
+	}
+	return template, nil
+}
+
+func unquoteSequence(environment Environment, templates []Term, depth int) ([]Term, Term) {
+	allocate(environment, len(templates))
+	terms := make([]Term, 0, len(templates))
+	for _, template := range templates {
+		if t, ok := template.(Application); ok && depth == 0 && len(t) == 2 && t[0] == Symbol("unquote-splicing") {
+			spliced, ok := datum(evaluate(environment, t[1])).(Tuple)
+			if !ok {
+				return nil, Error("unquote-splicing of a term that is not a tuple")
+			}
+			allocate(environment, len(spliced))
+			terms = append(terms, spliced...)
+			continue
+		}
+		term, err := unquote(environment, template, depth)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
@@ -0,0 +1,73 @@
+package hu
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{define x 2}
+{define xs (3 4)}
+{define s 'x}`)
+
+	formats := func(expected string) func(Term) bool {
+		return func(result Term) bool {
+			return Format(result) == expected
+		}
+	}
+	tests := []testCase{
+		{"'x", formats("'x")},
+		{"{quote x}", formats("'x")},
+		{"s", formats("'x")},
+		{"'5", is_eq_number(5)},
+		{"'(a b)", formats("(a b)")},
+		{"'{+ 1 x}", formats("'{+ 1 x}")},
+		{"''x", formats("''x")},
+		{"{eval '{+ 1 x}}", is_eq_number(3)},
+		{"{eval s}", is_eq_number(2)},
+		{"{quasiquote (1 {unquote x} {unquote-splicing xs})}", formats("(1 2 3 4)")},
+		{"{quasiquote {+ 1 {unquote x}}}", formats("'{+ 1 2}")},
+		{"{eval {quasiquote {+ {unquote-splicing xs}}}}", is_eq_number(7)},
+		{"{quasiquote (a {unquote s})}", formats("(a x)")},
+		{"{quasiquote (1 {quasiquote (2 {unquote {unquote x}})})}", formats("(1 {quasiquote (2 {unquote 2})})")},
+		{"{quasiquote ({unquote-splicing x})}", is_error()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, input := range []string{
+		`(a "b c" {f x 2/3} 'y (1 (2)) -4)`,
+		"{define (double (x)) {+ x x}}",
+		"'{quasiquote (1 {unquote x})}",
+		"`say \"hi\"`",
+		`""`,
+		`"say \"hi\" with ` + "`backquotes`" + `"`,
+		`"one\ntwo\tthree"`,
+		`"C:\\dir\\"`,
+	} {
+		if output := Format(Read(strings.NewReader(input))); output != input {
+			t.Errorf("%v was formatted as %v", input, output)
+		}
+	}
+	environment := make(LocalEnvironment)
+	AddDefaultBindings(environment)
+	set := Set{NewNumber(big.NewRat(1, 1)), Quote{Symbol("a")}, Tuple{String("b")}}
+	if result := GuardedEvaluate(environment, Read(strings.NewReader(Format(set)))); Format(result) != Format(set) {
+		t.Errorf("%v was formatted as %v, which evaluates to %v", set, Format(set), result)
+	} else if _, ok := result.(Set); !ok {
+		t.Errorf("%v evaluates to %v, which is not a set", Format(set), result)
+	}
+	for _, s := range []String{"a`b\"c", "a`b\nc", "a\\b", "a\\", `"\n"`} {
+		if read := Read(strings.NewReader(Format(s))); read != s {
+			t.Errorf("%q was formatted as %v, which reads back as %v", s, Format(s), read)
+		}
+	}
+}
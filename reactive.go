@@ -0,0 +1,392 @@
+package hu
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// Property is a variable that calls DidSet with its new value whenever it
+// changes, and then its observers with the new and old values. Before it
+// is set, its willSet observers are called in turn with the new and old
+// values, and the value each returns replaces the new value; if one
+// returns an error, the value is not set. A computed property has an
+// Expression, evaluated in Environment, rather than being set. The
+// properties read while its expression is evaluated become its sources,
+// and setting any of them recomputes it.
+//
+// Setting a property first invalidates every property computed from it,
+// directly or not, and then recomputes those with a DidSet handler in
+// dependency order, calling each handler once. A property is recomputed
+// only after all of its sources are up to date, so handlers never see a
+// mix of old and new values. Properties that are not observed are
+// recomputed when they are next read.
+type Property struct {
+	Name   Symbol
+	DidSet Abstraction
+
+	Expression  Term
+	Environment Environment
+
+	mutex      sync.Mutex
+	value      Term
+	dirty      bool
+	sources    map[*Property]bool
+	dependents map[*Property]bool
+	observers  []observer
+	next       int
+}
+
+// observer is a handler applied in hu, or a function called from Go, when
+// a property changes.
+type observer struct {
+	id       int
+	willSet  bool
+	handler  Term
+	function func(value, old Term)
+}
+
+func (o observer) call(environment Environment, value, old Term) Term {
+	if o.function != nil {
+		o.function(value, old)
+		return value
+	}
+	return evaluate(environment, Application([]Term{o.handler, value, old}))
+}
+
+func (property *Property) String() string {
+	return fmt.Sprintf("#<property> %v", property.Name)
+}
+
+func (property *Property) computed() bool {
+	return property.Expression != nil
+}
+
+func (property *Property) observed() bool {
+	property.mutex.Lock()
+	defer property.mutex.Unlock()
+	for _, o := range property.observers {
+		if !o.willSet {
+			return true
+		}
+	}
+	return property.DidSet.Term != nil
+}
+
+// observe adds o to the observers of property, and returns its id.
+func (property *Property) observe(environment Environment, o observer) int {
+	property.mutex.Lock()
+	property.next++
+	o.id = property.next
+	property.observers = append(property.observers, o)
+	property.mutex.Unlock()
+	if property.computed() && !o.willSet {
+		// Compute the property now so that its sources know to
+		// recompute it.
+		property.get(environment)
+	}
+	return o.id
+}
+
+// unobserve removes the observer with the given id, and reports whether
+// there was one.
+func (property *Property) unobserve(id int) bool {
+	property.mutex.Lock()
+	defer property.mutex.Unlock()
+	for i, o := range property.observers {
+		if o.id == id {
+			property.observers = append(property.observers[:i:i], property.observers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe calls function with the new and old values of property
+// whenever it changes, on the goroutine that changed it, until the
+// returned function is called.
+func (property *Property) Subscribe(function func(value, old Term)) (unsubscribe func()) {
+	var id int
+	GuardedEvaluate(property.Environment, Primitive(func(environment Environment) Term {
+		id = property.observe(environment, observer{function: function})
+		return nil
+	}))
+	return func() { property.unobserve(id) }
+}
+
+// handlers returns the observers of property that are, or are not,
+// willSet observers.
+func (property *Property) handlers(willSet bool) (handlers []observer) {
+	property.mutex.Lock()
+	defer property.mutex.Unlock()
+	for _, o := range property.observers {
+		if o.willSet == willSet {
+			handlers = append(handlers, o)
+		}
+	}
+	return
+}
+
+// get returns the value of property, first recomputing it if it is out of
+// date. Reading a property while computing another makes it a source of
+// the other.
+func (property *Property) get(environment Environment) Term {
+	readers := readersOf(environment)
+	for _, reader := range readers {
+		if reader == property {
+			return Error(fmt.Sprintf("cycle in computed property %v", property.Name))
+		}
+	}
+	if len(readers) > 0 {
+		readers[0].depend(property)
+	}
+
+	property.mutex.Lock()
+	value, dirty, sources := property.value, property.dirty, property.sources
+	if dirty {
+		property.sources = nil
+	}
+	property.mutex.Unlock()
+	if !dirty {
+		return value
+	}
+	for source := range sources {
+		source.mutex.Lock()
+		delete(source.dependents, property)
+		source.mutex.Unlock()
+	}
+
+	tracking := &trackingEnvironment{environment, property}
+	value = evaluate(&NestedEnvironment{Environment: newBindings(property.Environment), Parent: property.Environment, caller: tracking}, property.Expression)
+	property.mutex.Lock()
+	property.value, property.dirty = value, false
+	property.mutex.Unlock()
+	return value
+}
+
+// depend records that property was computed from source.
+func (property *Property) depend(source *Property) {
+	property.mutex.Lock()
+	if property.sources == nil {
+		property.sources = make(map[*Property]bool)
+	}
+	property.sources[source] = true
+	property.mutex.Unlock()
+
+	source.mutex.Lock()
+	if source.dependents == nil {
+		source.dependents = make(map[*Property]bool)
+	}
+	source.dependents[property] = true
+	source.mutex.Unlock()
+}
+
+func (property *Property) set(environment Environment, value Term) Term {
+	if property.computed() {
+		return Error(fmt.Sprintf("cannot set computed property %v", property.Name))
+	}
+	old := property.peek()
+	for _, o := range property.handlers(true) {
+		value = o.call(environment, value, old)
+		if isError(value) {
+			return value
+		}
+	}
+	if transaction := transactionOf(environment); transaction != nil {
+		transaction.set(property, value)
+		return nil
+	}
+	property.mutex.Lock()
+	property.value = value
+	property.mutex.Unlock()
+	property.didSet(environment, value, old)
+
+	for _, dependent := range invalidate(property) {
+		if dependent.observed() {
+			old := dependent.peek()
+			dependent.didSet(environment, dependent.get(environment), old)
+		}
+	}
+	return nil
+}
+
+// peek returns the value of property without recomputing it.
+func (property *Property) peek() Term {
+	property.mutex.Lock()
+	defer property.mutex.Unlock()
+	return property.value
+}
+
+func (property *Property) didSet(environment Environment, value, old Term) {
+	if property.DidSet.Term != nil {
+		evaluate(environment, Application([]Term{property.DidSet, value}))
+	}
+	for _, o := range property.handlers(false) {
+		o.call(environment, value, old)
+	}
+}
+
+// invalidate marks every property computed from any of sources as out of
+// date, and returns them in dependency order.
+func invalidate(sources ...*Property) []*Property {
+	var order []*Property
+	visited := make(map[*Property]bool)
+	for _, source := range sources {
+		visited[source] = true
+	}
+	var visit func(*Property)
+	visit = func(p *Property) {
+		p.mutex.Lock()
+		dependents := make([]*Property, 0, len(p.dependents))
+		for dependent := range p.dependents {
+			dependents = append(dependents, dependent)
+		}
+		p.mutex.Unlock()
+		for _, dependent := range dependents {
+			if !visited[dependent] {
+				visited[dependent] = true
+				visit(dependent)
+				order = append(order, dependent)
+			}
+		}
+	}
+	for _, source := range sources {
+		visit(source)
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	for _, dependent := range order {
+		dependent.mutex.Lock()
+		dependent.dirty = true
+		dependent.mutex.Unlock()
+	}
+	return order
+}
+
+// trackingEnvironment marks the evaluation of a computed property's
+// expression; it delegates to the environment the property was read in.
+type trackingEnvironment struct {
+	Environment
+	property *Property
+}
+
+func (environment *trackingEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *trackingEnvironment) wrapped() Environment {
+	return environment.Environment
+}
+
+// readersOf returns the computed properties being evaluated by the
+// evaluation environment is part of, innermost first.
+func readersOf(environment Environment) (readers []*Property) {
+	for ; environment != nil; environment = outer(environment) {
+		if e, ok := environment.(*trackingEnvironment); ok {
+			readers = append(readers, e.property)
+		}
+	}
+	return
+}
+
+// propertyOf returns the property bound to name, or an error term.
+func propertyOf(environment Environment, name Term) (*Property, Term) {
+	variable, ok := name.(Symbol)
+	if !ok {
+		return nil, Error("unexpected type for name")
+	}
+	value, ok := environment.Get(variable)
+	if !ok {
+		return nil, value
+	}
+	property, ok := value.(*Property)
+	if !ok {
+		return nil, Error(fmt.Sprintf("%v is not a property", variable))
+	}
+	return property, nil
+}
+
+func observe(environment Environment, term Term) Term {
+	return addObserver(environment, term, false)
+}
+
+func willSet(environment Environment, term Term) Term {
+	return addObserver(environment, term, true)
+}
+
+func addObserver(environment Environment, term Term, willSet bool) Term {
+	// price {lambda (new old) {print old "->" new}}
+	terms := term.(Tuple)
+	if len(terms) != 2 {
+		return Error("an observer needs a property and a handler")
+	}
+	property, err := propertyOf(environment, terms[0])
+	if err != nil {
+		return err
+	}
+	if willSet && property.computed() {
+		return Error(fmt.Sprintf("cannot set computed property %v", property.Name))
+	}
+	handler := evaluate(environment, terms[1])
+	if _, ok := handler.(Operator); !ok {
+		return Error("unexpected type for handler")
+	}
+	id := property.observe(environment, observer{willSet: willSet, handler: handler})
+	return NewNumber(big.NewRat(int64(id), 1))
+}
+
+func unobserve(environment Environment, term Term) Term {
+	terms := term.(Tuple)
+	if len(terms) != 2 {
+		return Error("unobserve needs a property and an observer")
+	}
+	property, err := propertyOf(environment, terms[0])
+	if err != nil {
+		return err
+	}
+	id, ok := evaluate(environment, terms[1]).(*Number)
+	if !ok {
+		return Error("unexpected type for observer")
+	}
+	n, ok := id.Int64()
+	return Boolean(ok && property.unobserve(int(n)))
+}
+
+// veto raises a veto error, which a willSet handler can use to prevent a
+// property from being set.
+func veto(environment Environment, term Term) Term {
+	var reason Term
+	if terms := term.(Tuple); len(terms) > 0 {
+		reason = evaluate(environment, terms[0])
+	}
+	return RaisedError{"veto", reason}
+}
+
+func computed(environment Environment, term Term) Term {
+	// total {+ price tax} {lambda (newTotal) {print newTotal}}
+	terms := term.(Tuple)
+	if len(terms) < 2 {
+		return Error("computed needs a name and an expression")
+	}
+	name, ok := terms[0].(Symbol)
+	if !ok {
+		return Error("unexpected type for name")
+	}
+	property := &Property{Name: name, Expression: terms[1], Environment: environment, dirty: true}
+	if len(terms) > 2 {
+		didSet, ok := evaluate(environment, terms[2]).(Abstraction)
+		if !ok {
+			return Error("unexpected type for didSet")
+		}
+		property.DidSet = didSet
+	}
+	bind(environment, 1)
+	environment.Define(name, property)
+	if property.observed() {
+		// Observed properties are computed now so that their sources
+		// know to recompute them.
+		property.get(environment)
+	}
+	return nil
+}
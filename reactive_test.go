@@ -0,0 +1,111 @@
+package hu
+
+import (
+	"testing"
+)
+
+func TestComputed(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{variable a}
+{variable b}
+{set a 1}
+{set b 2}
+{computed sum {+ a b}}
+{computed double {* sum 2}}
+{variable choice}
+{set choice true}
+{define picks 0}
+{computed pick {if choice a b} {lambda (v) {set picks {+ picks 1}}}}
+{define count 0}
+{define seen nil}
+{computed left {+ a 1}}
+{computed right {* a 2}}
+{computed diamond {+ left right} {lambda (v) {begin {set count {+ count 1}} {set seen v}}}}
+{computed x {+ y 1}}
+{computed y {+ x 1}}`)
+
+	tests := []testCase{
+		{"sum", is_eq_number(3)},
+		{"{get sum}", is_eq_number(3)},
+		{"{begin {set a 10} sum}", is_eq_number(12)},
+		{"double", is_eq_number(24)},
+		{"{begin {set b 20} double}", is_eq_number(60)},
+		{"{set sum 1}", is_error()},
+		{"x", is_error()},
+		// Each change of a fires the diamond's handler once, with left and
+		// right both up to date.
+		{"{begin {set count 0} {set a 5} count}", is_eq_number(1)},
+		{"seen", is_eq_number(16)},
+		// pick reads a or b depending on choice, and only depends on the
+		// one it read last.
+		{"{begin {set picks 0} {set choice false} picks}", is_eq_number(1)},
+		{"pick", is_eq_number(20)},
+		{"{begin {set a 100} picks}", is_eq_number(1)},
+		{"{begin {set b 200} picks}", is_eq_number(2)},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{variable price}
+{set price 10}
+{computed total {* price 2}}
+{define changes 0}
+{define last 0}
+{define previous 0}
+{define watcher 0}
+{define counter 0}
+{set watcher {observe price {lambda (new old) {begin {set changes {+ changes 1}} {set last new} {set previous old}}}}}
+{set counter {observe price {lambda (new old) {set changes {+ changes 1}}}}}
+{willSet price {lambda (new old) {if {< new 0} {veto "negative price"} {if {< 100 new} 100 new}}}}
+{define totals 0}
+{observe total {lambda (new old) {set totals new}}}`)
+
+	var values, olds []Term
+	unsubscribe, err := interpreter.Subscribe("price", func(value, old Term) {
+		values = append(values, value)
+		olds = append(olds, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := interpreter.Subscribe("changes", func(value, old Term) {}); err == nil {
+		t.Errorf("expected an error subscribing to a variable that is not a property")
+	}
+
+	tests := []testCase{
+		{"{begin {set price 20} changes}", is_eq_number(2)},
+		{"last", is_eq_number(20)},
+		{"previous", is_eq_number(10)},
+		{"totals", is_eq_number(40)},
+		{"{set price -1}", is_raised("veto")},
+		{"price", is_eq_number(20)},
+		{"{begin {set price 500} price}", is_eq_number(100)},
+		{"{unobserve price counter}", is_eq(Boolean(true))},
+		{"{unobserve price counter}", is_eq(Boolean(false))},
+		{"{begin {set changes 0} {set price 30} changes}", is_eq_number(1)},
+		{"{observe changes {lambda (new old) new}}", is_error()},
+		{"{willSet total {lambda (new old) new}}", is_error()},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+
+	if len(values) != 3 || !is_eq_number(30)(values[2]) || !is_eq_number(100)(olds[2]) {
+		t.Errorf("unexpected values %v and olds %v", values, olds)
+	}
+	unsubscribe()
+	interpreter.Eval("{set price 40}")
+	if len(values) != 3 {
+		t.Errorf("expected no values after unsubscribing, got %v", values)
+	}
+}
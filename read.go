@@ -256,10 +256,19 @@ func lexItem(l *reader) stateFn {
 	return nil
 }
 
-// lexWord scans an alphanumeric
+// lexWord scans an alphanumeric word, which may contain hyphens
+// (e.g. json-parse). A hyphen that is not followed by a letter or digit
+// ends the word and is emitted as punctuation.
 func lexWord(l *reader) stateFn {
 top:
 	switch r := l.next(); {
+	case r == '-' && isAlphaNumeric(l.peek()):
+		goto top
+	case r == '-':
+		l.current.Truncate(l.current.Len() - 1)
+		l.emit(itemWord)
+		l.current.WriteRune(r)
+		l.emit(itemPunctuation)
 	case isPunctuation(r):
 		l.backup()
 		l.emit(itemWord)
@@ -283,10 +292,18 @@ func lexPunctuation(l *reader) stateFn {
 		l.emit(itemCloseCurlyBrace)
 	case '\'':
 		l.emit(itemQuote)
-	case ' ':
+	case ' ', '\t', '\r':
 		l.emit(itemSpace)
 	case '.':
-		l.emit(itemPeriod)
+		if l.peek() != '.' {
+			l.emit(itemPeriod)
+			break
+		}
+		// An ellipsis is a word.
+		for l.peek() == '.' {
+			l.next()
+		}
+		l.emit(itemWord)
 	case '\f':
 		l.emit(itemPageBreak)
 	case '§':
@@ -381,6 +398,29 @@ Loop:
 	return lexPunctuation
 }
 
+// escapes are the escapes of a double quoted string; a backslash before
+// any other character is kept as it is.
+var escapes = map[byte]byte{'\\': '\\', '"': '"', 'n': '\n', 't': '\t', 'r': '\r'}
+
+// unescape replaces the escapes in the text of a double quoted string.
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if c, ok := escapes[s[i+1]]; ok {
+				b.WriteByte(c)
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 // isPunctuation reports whether r is a punctuation character.
 func isPunctuation(r rune) bool {
 	switch r {
@@ -400,17 +440,23 @@ func isAlphaNumeric(r rune) bool {
 func (reader *reader) read() (term Term) {
 	switch token := reader.nextItem(); token.typ {
 	case itemString:
-		term = String(strings.Trim(token.val, string("\"`")))
+		if token.val[0] == '"' {
+			term = String(unescape(token.val[1 : len(token.val)-1]))
+		} else {
+			term = String(token.val[1 : len(token.val)-1])
+		}
 	case itemNumber:
 		num := big.NewRat(0, 1)
 		num.SetString(token.val)
 		term = &Number{num}
 	case itemOpenParenthesis:
-		part := &partDescription{ignore: 1<<itemSpace | 1<<itemCloseParenthesis, end: 1 << itemCloseParenthesis}
+		part := &partDescription{ignore: 1<<itemSpace | 1<<itemNewline | 1<<itemCloseParenthesis, end: 1 << itemCloseParenthesis}
 		term = Tuple(reader.readPart(part))
 	case itemOpenCurlyBrace:
-		part := &partDescription{ignore: 1<<itemSpace | 1<<itemCloseCurlyBrace, end: 1 << itemCloseCurlyBrace}
+		part := &partDescription{ignore: 1<<itemSpace | 1<<itemNewline | 1<<itemCloseCurlyBrace, end: 1 << itemCloseCurlyBrace}
 		term = Application(reader.readPart(part))
+	case itemQuote:
+		term = quoted(reader.read())
 	case itemEOF:
 		reader.backupItem()
 		term = nil
@@ -439,6 +485,8 @@ func Read(in io.RuneScanner) (result Term) {
 type partDescription struct {
 	ignore, start, end uint64
 	sub                *partDescription
+	// prose reads quotes as apostrophes rather than quoting terms.
+	prose bool
 }
 
 func (reader *reader) readPart(part *partDescription) (result Part) {
@@ -459,7 +507,9 @@ func (reader *reader) readPart(part *partDescription) (result Part) {
 			reader.nextItem()
 		} else {
 			var t Term
-			if part.sub == nil || 1<<token.typ&part.end != 0 {
+			if part.prose && token.typ == itemQuote {
+				t = Symbol(reader.nextItem().val)
+			} else if part.sub == nil || 1<<token.typ&part.end != 0 {
 				t = reader.read()
 			} else {
 				t = reader.readPart(part.sub)
@@ -474,15 +524,15 @@ func (reader *reader) readPart(part *partDescription) (result Part) {
 }
 
 func ReadDocument(in io.RuneScanner) Part {
-	line := &partDescription{end: 1 << itemNewline}
-	part := &partDescription{end: 1 << itemNewline, sub: line}
+	line := &partDescription{end: 1 << itemNewline, prose: true}
+	part := &partDescription{end: 1 << itemNewline, sub: line, prose: true}
 	section := &partDescription{start: 1 << itemSection, sub: part}
 	document := &partDescription{sub: section}
 	return newReader("", in).readPart(document)
 }
 
 func ReadSentence(in io.RuneScanner) []Term {
-	line := &partDescription{ignore: 1<<itemPeriod | 1<<itemSpace | 1<<itemNewline, end: 1 << itemPeriod}
+	line := &partDescription{ignore: 1<<itemPeriod | 1<<itemSpace | 1<<itemNewline, end: 1 << itemPeriod, prose: true}
 	return newReader("", in).readPart(line)
 }
 
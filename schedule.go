@@ -0,0 +1,283 @@
+package hu
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// scheduler holds the jobs scheduled on an interpreter. Timers put jobs
+// that come due on a queue, and the interpreter's event loop evaluates
+// them one at a time.
+type scheduler struct {
+	mutex sync.Mutex
+	jobs  map[int]*job
+	next  int
+	due   []*job
+	wake  chan struct{}
+}
+
+// job applies handler, with no operands, when its timer fires. A job
+// scheduled by every is repeated each interval.
+type job struct {
+	id          int
+	handler     Term
+	every       bool
+	interval    time.Duration
+	timer       Timer
+	unsubscribe func()
+}
+
+func (s *scheduler) init() {
+	if s.jobs == nil {
+		s.jobs = make(map[int]*job)
+		s.wake = make(chan struct{}, 1)
+	}
+}
+
+// add assigns job an id and records it.
+func (s *scheduler) add(j *job) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.init()
+	s.next++
+	j.id = s.next
+	s.jobs[j.id] = j
+	return j.id
+}
+
+// start times job to come due after d, replacing any timer it has.
+func (interpreter *Interpreter) start(j *job, d time.Duration) {
+	s := &interpreter.scheduler
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	interpreter.startLocked(j, d)
+}
+
+func (interpreter *Interpreter) startLocked(j *job, d time.Duration) {
+	s := &interpreter.scheduler
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	var timer Timer
+	timer = interpreter.clock.AfterFunc(d, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.jobs[j.id] != j || j.timer != timer {
+			return
+		}
+		s.due = append(s.due, j)
+		j.timer = nil
+		if j.every {
+			interpreter.startLocked(j, j.interval)
+		}
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	})
+	j.timer = timer
+}
+
+// Cancel cancels the job with the given id, and reports whether there
+// was one.
+func (interpreter *Interpreter) Cancel(id int) bool {
+	s := &interpreter.scheduler
+	s.mutex.Lock()
+	j, ok := s.jobs[id]
+	if ok {
+		delete(s.jobs, id)
+		if j.timer != nil {
+			j.timer.Stop()
+		}
+	}
+	s.mutex.Unlock()
+	if ok && j.unsubscribe != nil {
+		j.unsubscribe()
+	}
+	return ok
+}
+
+// RunPending evaluates the jobs that have come due, and returns how many
+// it evaluated.
+func (interpreter *Interpreter) RunPending() int {
+	return interpreter.runDue(context.Background())
+}
+
+// Run is the interpreter's event loop: it evaluates jobs as they come
+// due, until ctx is done. Jobs are evaluated one at a time on the
+// goroutine calling Run, so a program that also evaluates on other
+// goroutines needs an environment that is safe for concurrent use.
+func (interpreter *Interpreter) Run(ctx context.Context) error {
+	s := &interpreter.scheduler
+	s.mutex.Lock()
+	s.init()
+	wake := s.wake
+	s.mutex.Unlock()
+	for {
+		interpreter.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+func (interpreter *Interpreter) runDue(ctx context.Context) (n int) {
+	s := &interpreter.scheduler
+	for {
+		s.mutex.Lock()
+		if len(s.due) == 0 || ctx.Err() != nil {
+			s.mutex.Unlock()
+			return
+		}
+		j := s.due[0]
+		s.due = s.due[1:]
+		_, scheduled := s.jobs[j.id]
+		s.mutex.Unlock()
+		if !scheduled {
+			continue
+		}
+		if !j.every {
+			interpreter.Cancel(j.id)
+		}
+		result := interpreter.Evaluate(ctx, Application{j.handler})
+		if isError(result) {
+			interpreter.logger.Println("job", j.id, result)
+		}
+		n++
+	}
+}
+
+// duration returns the duration denoted by term: a number of seconds, or
+// a string such as "1m30s".
+func duration(term Term) (time.Duration, bool) {
+	switch t := term.(type) {
+	case *Number:
+		nanoseconds := new(big.Rat).Mul(t.value, big.NewRat(int64(time.Second), 1))
+		n := new(big.Int).Quo(nanoseconds.Num(), nanoseconds.Denom())
+		if !n.IsInt64() || n.Sign() < 0 {
+			return 0, false
+		}
+		return time.Duration(n.Int64()), true
+	case String:
+		d, err := time.ParseDuration(string(t))
+		return d, err == nil && d >= 0
+	}
+	return 0, false
+}
+
+// instant returns the time denoted by term: a number of seconds since
+// the Unix epoch, or a string in RFC 3339 format.
+func instant(term Term) (time.Time, bool) {
+	switch t := term.(type) {
+	case *Number:
+		nanoseconds := new(big.Rat).Mul(t.value, big.NewRat(int64(time.Second), 1))
+		n := new(big.Int).Quo(nanoseconds.Num(), nanoseconds.Denom())
+		if !n.IsInt64() {
+			return time.Time{}, false
+		}
+		return time.Unix(0, n.Int64()), true
+	case String:
+		instant, err := time.Parse(time.RFC3339, string(t))
+		return instant, err == nil
+	}
+	return time.Time{}, false
+}
+
+// schedule schedules a job applying the handler in terms[1] after the
+// delay that delay computes from terms[0]. If terms[0] names a property,
+// the job is rescheduled whenever the property changes.
+func schedule(environment Environment, term Term, every bool, delay func(Term) (time.Duration, bool)) Term {
+	interpreter := interpreterOf(environment)
+	if interpreter == nil {
+		return Error("jobs can only be scheduled by an interpreter")
+	}
+	terms := term.(Tuple)
+	if len(terms) != 2 {
+		return Error("a job needs a time and a handler")
+	}
+	handler := evaluate(environment, terms[1])
+	if _, ok := handler.(Operator); !ok {
+		return Error("unexpected type for handler")
+	}
+	var property *Property
+	if name, ok := terms[0].(Symbol); ok {
+		value, _ := environment.Get(name)
+		property, _ = value.(*Property)
+	}
+	value := evaluate(environment, terms[0])
+	d, ok := delay(value)
+	if !ok {
+		return Error(fmt.Sprintf("invalid time %v", value))
+	}
+
+	j := &job{handler: handler, every: every, interval: d}
+	id := interpreter.scheduler.add(j)
+	interpreter.start(j, d)
+	if property != nil {
+		j.unsubscribe = property.Subscribe(func(value, old Term) {
+			d, ok := delay(value)
+			s := &interpreter.scheduler
+			s.mutex.Lock()
+			_, scheduled := s.jobs[id]
+			if ok {
+				j.interval = d
+			}
+			s.mutex.Unlock()
+			if scheduled && ok {
+				interpreter.start(j, d)
+			}
+		})
+	}
+	return NewNumber(big.NewRat(int64(id), 1))
+}
+
+func after(environment Environment, term Term) Term {
+	return schedule(environment, term, false, duration)
+}
+
+func every(environment Environment, term Term) Term {
+	return schedule(environment, term, true, func(term Term) (time.Duration, bool) {
+		d, ok := duration(term)
+		return d, ok && d > 0
+	})
+}
+
+func at(environment Environment, term Term) Term {
+	clock := systemClock{}.Now
+	if interpreter := interpreterOf(environment); interpreter != nil {
+		clock = interpreter.clock.Now
+	}
+	return schedule(environment, term, false, func(term Term) (time.Duration, bool) {
+		instant, ok := instant(term)
+		if !ok {
+			return 0, false
+		}
+		d := instant.Sub(clock())
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	})
+}
+
+func cancel(environment Environment, term Term) Term {
+	interpreter := interpreterOf(environment)
+	if interpreter == nil {
+		return Error("jobs can only be canceled by an interpreter")
+	}
+	terms := term.(Tuple)
+	if len(terms) != 1 {
+		return Error("cancel needs a job")
+	}
+	id, ok := evaluate(environment, terms[0]).(*Number)
+	if !ok {
+		return Error("unexpected type for job")
+	}
+	n, ok := id.Int64()
+	return Boolean(ok && interpreter.Cancel(int(n)))
+}
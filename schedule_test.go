@@ -0,0 +1,83 @@
+package hu
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var logged bytes.Buffer
+	interpreter := NewInterpreter(WithClock(clock), WithLogger(log.New(&logged, "", 0)))
+	interpreter.Eval(`{define once 0}
+{define ticks 0}
+{define noon 0}
+{define job 0}
+{variable period}
+{set period 10}
+{after "1500ms" {lambda () {set once {+ once 1}}}}
+{set job {every period {lambda () {set ticks {+ ticks 1}}}}}
+{at "2024-01-01T12:00:00Z" {lambda () {set noon 1}}}
+{after 1 {lambda () {set missing 1}}}`)
+
+	steps := []struct {
+		advance     time.Duration
+		input       string
+		is_expected func(Term) bool
+	}{
+		{time.Second, "once", is_eq_number(0)},
+		{time.Second, "once", is_eq_number(1)},
+		{10 * time.Second, "once", is_eq_number(1)},
+		{0, "ticks", is_eq_number(1)},
+		{25 * time.Second, "ticks", is_eq_number(3)},
+		// Changing the period reschedules the job from now.
+		{0, "{set period 60}", is_eq(nil)},
+		{50 * time.Second, "ticks", is_eq_number(3)},
+		{10 * time.Second, "ticks", is_eq_number(4)},
+		{12 * time.Hour, "noon", is_eq_number(1)},
+		{0, "{cancel job}", is_eq(Boolean(true))},
+		{0, "{begin {set ticks 0} {cancel job}}", is_eq(Boolean(false))},
+		{time.Hour, "ticks", is_eq_number(0)},
+		{0, `{after "soon" {lambda () 1}}`, is_error()},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		interpreter.RunPending()
+		if result := interpreter.Eval(step.input); !step.is_expected(result) {
+			t.Errorf("after %v, %v unexpectedly resulted in %v", clock.Now(), step.input, result)
+		}
+	}
+	if !strings.Contains(logged.String(), "missing") {
+		t.Errorf("expected the failed job to be logged, got %q", logged.String())
+	}
+
+	if result := GuardedEvaluate(interpreter.Environment(), Read(strings.NewReader("{after 1 {lambda () 1}}"))); !is_error()(result) {
+		t.Errorf("expected an error scheduling without an interpreter, got %v", result)
+	}
+}
+
+func TestRun(t *testing.T) {
+	// Run evaluates jobs on its own goroutine, concurrently with Eval.
+	interpreter := NewInterpreter(WithEnvironment(NewSyncEnvironment()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- interpreter.Run(ctx) }()
+	interpreter.Define("fired", Boolean(false))
+	interpreter.Eval(`{after "1ms" {lambda () {set fired true}}}`)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if is_eq(Boolean(true))(interpreter.Eval("fired")) {
+			break
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected Run to return when canceled, got %v", err)
+	}
+	if result := interpreter.Eval("fired"); !is_eq(Boolean(true))(result) {
+		t.Errorf("expected the job to have run, got %v", result)
+	}
+}
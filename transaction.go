@@ -0,0 +1,235 @@
+package hu
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// A Transaction groups the sets made in it. Their values are visible in
+// the transaction at once, but observers are only notified when it
+// commits, once for each property changed, with the value it had before
+// the transaction as the old value. If the transaction rolls back, every
+// assignment made in it is undone and no observer is notified.
+type Transaction struct {
+	mutex       sync.Mutex
+	journal     []change
+	before      map[*Property]Term
+	done        bool
+	environment Environment
+}
+
+// change records the value of a variable or property before it was set.
+type change struct {
+	property    *Property
+	environment Environment
+	name        Symbol
+	old         Term
+}
+
+// transactionEnvironment marks the evaluation of the body of a
+// transaction; it delegates to the environment the body is evaluated in.
+type transactionEnvironment struct {
+	Environment
+	transaction *Transaction
+}
+
+func (environment *transactionEnvironment) String() string {
+	return "#<environment>"
+}
+
+func (environment *transactionEnvironment) wrapped() Environment {
+	return environment.Environment
+}
+
+// transactionOf returns the innermost transaction the evaluation
+// environment is part of, or nil if there is none.
+func transactionOf(environment Environment) *Transaction {
+	for ; environment != nil; environment = outer(environment) {
+		if e, ok := environment.(*transactionEnvironment); ok && !e.transaction.finished() {
+			return e.transaction
+		}
+	}
+	return nil
+}
+
+func (transaction *Transaction) finished() bool {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+	return transaction.done
+}
+
+func (transaction *Transaction) record(c change) {
+	transaction.mutex.Lock()
+	transaction.journal = append(transaction.journal, c)
+	transaction.mutex.Unlock()
+}
+
+func (transaction *Transaction) mark() int {
+	transaction.mutex.Lock()
+	defer transaction.mutex.Unlock()
+	return len(transaction.journal)
+}
+
+// set sets property to value, invalidating the properties computed from
+// it without notifying their observers.
+func (transaction *Transaction) set(property *Property, value Term) {
+	property.mutex.Lock()
+	old := property.value
+	property.value = value
+	property.mutex.Unlock()
+	transaction.record(change{property: property, old: old})
+	for _, dependent := range invalidate(property) {
+		transaction.mutex.Lock()
+		if _, ok := transaction.before[dependent]; !ok {
+			transaction.before[dependent] = dependent.peek()
+		}
+		transaction.mutex.Unlock()
+	}
+}
+
+// rollback undoes the changes recorded after mark, most recent first.
+func (transaction *Transaction) rollback(mark int) {
+	transaction.mutex.Lock()
+	journal := transaction.journal[mark:]
+	transaction.journal = transaction.journal[:mark:mark]
+	transaction.mutex.Unlock()
+	for i := len(journal) - 1; i >= 0; i-- {
+		c := journal[i]
+		if c.property != nil {
+			c.property.mutex.Lock()
+			c.property.value = c.old
+			c.property.mutex.Unlock()
+			invalidate(c.property)
+		} else {
+			c.environment.Set(c.name, c.old)
+		}
+	}
+}
+
+// commit ends the transaction and notifies the observers of the
+// properties changed in it.
+func (transaction *Transaction) commit(environment Environment) {
+	transaction.mutex.Lock()
+	journal := transaction.journal
+	before := transaction.before
+	transaction.done = true
+	transaction.mutex.Unlock()
+
+	var properties []*Property
+	olds := make(map[*Property]Term)
+	for _, c := range journal {
+		if c.property != nil {
+			if _, ok := olds[c.property]; !ok {
+				olds[c.property] = c.old
+				properties = append(properties, c.property)
+			}
+		}
+	}
+	for _, property := range properties {
+		property.didSet(environment, property.peek(), olds[property])
+	}
+	for _, dependent := range invalidate(properties...) {
+		if dependent.observed() {
+			old, ok := before[dependent]
+			if !ok {
+				old = dependent.peek()
+			}
+			dependent.didSet(environment, dependent.get(environment), old)
+		}
+	}
+}
+
+// finish ends the transaction without notifying any observers.
+func (transaction *Transaction) finish() {
+	transaction.mutex.Lock()
+	transaction.done = true
+	transaction.mutex.Unlock()
+}
+
+// evaluate calls evaluate with an environment extending environment that
+// is part of transaction. If it returns an error or aborts, the changes
+// it made are rolled back. Unless transaction is nested in another one,
+// it then commits or, if the changes were rolled back, finishes.
+func (transaction *Transaction) evaluate(environment Environment, nested bool, evaluate func(Environment) Term) (result Term) {
+	mark := transaction.mark()
+	defer func() {
+		if r := recover(); r != nil {
+			transaction.rollback(mark)
+			if !nested {
+				transaction.finish()
+			}
+			panic(r)
+		}
+	}()
+	result = evaluate(&transactionEnvironment{environment, transaction})
+	switch {
+	case isError(result):
+		transaction.rollback(mark)
+		if !nested {
+			transaction.finish()
+		}
+	case !nested:
+		transaction.commit(environment)
+	}
+	return result
+}
+
+func newTransaction() *Transaction {
+	return &Transaction{before: make(map[*Property]Term)}
+}
+
+func transactionPrimitive(environment Environment, term Term) Term {
+	transaction, nested := transactionOf(environment), true
+	if transaction == nil {
+		transaction, nested = newTransaction(), false
+	}
+	return transaction.evaluate(environment, nested, func(environment Environment) (result Term) {
+		for _, expression := range term.(Tuple) {
+			result = evaluate(environment, expression)
+			if isError(result) {
+				break
+			}
+		}
+		return
+	})
+}
+
+// Transaction calls f with a new transaction, which commits if f returns
+// nil and rolls back otherwise.
+func (interpreter *Interpreter) Transaction(ctx context.Context, f func(*Transaction) error) (err error) {
+	c := newControl(ctx, interpreter.limits, interpreter)
+	environment := &controlledEnvironment{interpreter.environment, c}
+	transaction := newTransaction()
+	result := GuardedEvaluate(environment, Primitive(func(environment Environment) Term {
+		return transaction.evaluate(environment, false, func(environment Environment) Term {
+			transaction.environment = environment
+			if err = f(transaction); err != nil {
+				return Error(err.Error())
+			}
+			return nil
+		})
+	}))
+	if err == nil && isError(result) {
+		err = fmt.Errorf("%v", result)
+	}
+	return err
+}
+
+// Set sets the variable or property bound to name to value, as the set
+// form does.
+func (transaction *Transaction) Set(name string, value Term) error {
+	result := GuardedEvaluate(transaction.environment, Primitive(func(environment Environment) Term {
+		return assign(environment, Symbol(name), value)
+	}))
+	if isError(result) {
+		return fmt.Errorf("%v", result)
+	}
+	return nil
+}
+
+// Evaluate evaluates term as part of the transaction, like
+// GuardedEvaluate.
+func (transaction *Transaction) Evaluate(term Term) Term {
+	return GuardedEvaluate(transaction.environment, term)
+}
@@ -0,0 +1,80 @@
+package hu
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.Eval(`{variable width}
+{variable height}
+{set width 2}
+{set height 3}
+{computed area {* width height}}
+{define notifications 0}
+{define areas 0}
+{define olds 0}
+{observe width {lambda (new old) {set notifications {+ notifications 1}}}}
+{observe height {lambda (new old) {set notifications {+ notifications 1}}}}
+{observe area {lambda (new old) {begin {set areas {+ areas 1}} {set olds old}}}}
+{define plain 1}`)
+
+	tests := []testCase{
+		// Observers run once after commit, and see the final values.
+		{"{transaction {set width 4} {set height 5} {set width 6} area}", is_eq_number(30)},
+		{"notifications", is_eq_number(2)},
+		{"areas", is_eq_number(1)},
+		{"olds", is_eq_number(6)},
+		// An error rolls back every assignment and notifies no one.
+		{"{transaction {set width 100} {set plain 2} {set missing 1} {set height 100}}", is_unbound()},
+		{"width", is_eq_number(6)},
+		{"plain", is_eq_number(1)},
+		{"area", is_eq_number(30)},
+		{"notifications", is_eq_number(2)},
+		{"areas", is_eq_number(1)},
+		// A nested transaction that fails only rolls back its own changes.
+		{"{transaction {set width 1} {try {transaction {set height 100} {set missing 1}} (catch {lambda (e) 0})} area}", is_eq_number(5)},
+		{"height", is_eq_number(5)},
+		{"areas", is_eq_number(2)},
+	}
+	for _, test := range tests {
+		result := interpreter.Eval(test.input)
+		if !test.is_expected(result) {
+			t.Errorf("%v unexpectedly resulted in %v", test.input, result)
+		}
+	}
+
+	var areas []Term
+	unsubscribe, _ := interpreter.Subscribe("area", func(value, old Term) {
+		areas = append(areas, value)
+	})
+	defer unsubscribe()
+	err := interpreter.Transaction(context.Background(), func(transaction *Transaction) error {
+		transaction.Set("width", NewNumber(big.NewRat(7, 1)))
+		transaction.Set("height", NewNumber(big.NewRat(8, 1)))
+		if area := transaction.Evaluate(Symbol("area")); !is_eq_number(56)(area) {
+			t.Errorf("expected area 56 in the transaction, got %v", area)
+		}
+		return nil
+	})
+	if err != nil || len(areas) != 1 || !is_eq_number(56)(areas[0]) {
+		t.Errorf("commit: unexpected %v %v", err, areas)
+	}
+	failure := errors.New("failure")
+	err = interpreter.Transaction(context.Background(), func(transaction *Transaction) error {
+		transaction.Set("width", NewNumber(big.NewRat(9, 1)))
+		return failure
+	})
+	if err == nil || len(areas) != 1 || !is_eq_number(7)(interpreter.Eval("width")) {
+		t.Errorf("rollback: unexpected %v %v %v", err, areas, interpreter.Eval("width"))
+	}
+	err = interpreter.Transaction(context.Background(), func(transaction *Transaction) error {
+		return transaction.Set("missing", nil)
+	})
+	if err == nil {
+		t.Errorf("expected an error setting an unbound variable")
+	}
+}